@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ------------------------------
+// Run changelog (CHANGELOG_OUT)
+// ------------------------------
+
+// changelogPath (CHANGELOG_OUT) is the Markdown file written at the end of the run: run id,
+// settings, fetch filters, per-table counts, duration and a few before/after samples, meant to
+// be committed to a migrations log, so signature/token params in the samples are redacted. It
+// is overwritten on every run.
+var changelogPath string
+
+// changelogSamplesPerTable caps the before/after pairs kept per table.
+const changelogSamplesPerTable = 3
+
+// changelogSamples holds the first change records of each table. recordChange adds to it while
+// holding recordMu.
+var changelogSamples = make(map[string][]changeRecord)
+
+func addChangelogSample(rec changeRecord) {
+	if changelogPath == "" || len(changelogSamples[rec.Table]) >= changelogSamplesPerTable {
+		return
+	}
+	changelogSamples[rec.Table] = append(changelogSamples[rec.Table], rec)
+}
+
+// writeChangelog writes the CHANGELOG_OUT file for a run that started at started and ended
+// with runErr. It is best-effort: failures are logged, never returned.
+func writeChangelog(started time.Time, dryRun bool, batchSize int, runErr error) {
+	if changelogPath == "" {
+		return
+	}
+	finished := time.Now()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Remove tagging run %s\n\n", runID)
+	outcome := "completed"
+	if runErr != nil {
+		outcome = "failed: " + runErr.Error()
+	}
+	fmt.Fprintf(&b, "- Outcome: %s\n", outcome)
+	fmt.Fprintf(&b, "- Started: %s\n", started.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Finished: %s (%s)\n", finished.Format(time.RFC3339), finished.Sub(started).Round(time.Second))
+	fmt.Fprintf(&b, "- Dry run: %v\n", dryRun)
+	fmt.Fprintf(&b, "- Tag params: `%s`\n", strings.Join(tagParams, ","))
+	fmt.Fprintf(&b, "- Batch size: %d\n", batchSize)
+	if shadowApply {
+		b.WriteString("- Writes went to the `*_shadow` tables (SHADOW_APPLY)\n")
+	}
+	if copyMode {
+		b.WriteString("- Writes went to the `*_cleaned` tables (COPY_MODE)\n")
+	}
+	if maxWrites > 0 {
+		fmt.Fprintf(&b, "- MAX_WRITES: %d (used %d)\n", maxWrites, writesDone)
+	}
+	if canaryPercent > 0 {
+		fmt.Fprintf(&b, "- Canary: %d%% of rows\n", canaryPercent)
+	}
+	for _, table := range allTables() {
+		if ids, ok := idsLists[table]; ok {
+			fmt.Fprintf(&b, "- Pk list: %d listed %s pks\n", len(ids), table)
+		}
+	}
+	if urlIncludeRegex != nil {
+		fmt.Fprintf(&b, "- URL_INCLUDE_REGEX: `%s`\n", urlIncludeRegex)
+	}
+	if urlExcludeRegex != nil {
+		fmt.Fprintf(&b, "- URL_EXCLUDE_REGEX: `%s`\n", urlExcludeRegex)
+	}
+
+	b.WriteString("\n## Tables\n\n")
+	b.WriteString("| table | rows scanned | rows updated | rows skipped | URLs cleaned |\n")
+	b.WriteString("|---|---:|---:|---:|---:|\n")
+	summariesMu.Lock()
+	for _, s := range summaries {
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | %d |\n", s.name, s.rows, s.updated, s.skipped, s.urlsCleaned)
+	}
+	summariesMu.Unlock()
+
+	if len(dsnResults) > 0 {
+		b.WriteString("\n## Databases\n\n")
+		b.WriteString("| dsn | status | rows scanned | rows updated | rows skipped | URLs cleaned |\n")
+		b.WriteString("|---|---|---:|---:|---:|---:|\n")
+		for _, r := range dsnResults {
+			status := "ok"
+			if r.err != nil {
+				status = "failed"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %d | %d | %d | %d |\n", r.label, status, r.totals.rows, r.totals.updated, r.totals.skipped, r.totals.urlsCleaned)
+		}
+	}
+
+	b.WriteString("\n## Filters\n\nFirst-batch fetch query of each selected table:\n")
+	for _, q := range selectedBatchQueries() {
+		query, args := q.build(batchSize)
+		fmt.Fprintf(&b, "\n### %s\n\n```sql\n%s\n```\n", q.table, strings.TrimSpace(query))
+		if len(args) > 0 {
+			shown := make([]string, 0, len(args))
+			for _, a := range args {
+				shown = append(shown, fmt.Sprintf("`%v`", a))
+			}
+			fmt.Fprintf(&b, "\nArguments: %s\n", strings.Join(shown, ", "))
+		}
+	}
+
+	b.WriteString("\n## Samples\n")
+	recordMu.Lock()
+	for _, table := range allTables() {
+		recs := changelogSamples[table]
+		if len(recs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n", table)
+		for _, rec := range recs {
+			for _, c := range rec.Columns {
+				fmt.Fprintf(&b, "\n%s=%s `%s`\n\n```diff\n- %s\n+ %s\n```\n", rec.PKColumn, rec.PK, c.Name, redactSecrets(c.Old), redactSecrets(c.New))
+			}
+		}
+	}
+	recordMu.Unlock()
+
+	if err := os.WriteFile(changelogPath, []byte(b.String()), 0o644); err != nil {
+		log.Printf("[WARN] failed to write changelog %s: %v", changelogPath, err)
+		return
+	}
+	log.Printf("changelog written to %s", changelogPath)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Client write-column mapping (CLIENT_COLUMN_MAP)
+// ------------------------------
+
+// clientWriteColumns maps a client attachment column (read) -> the column the cleaned value
+// is written to, configured via CLIENT_COLUMN_MAP="client_contract_attachment_url:client_contract_attachment_url_v2".
+// Unmapped columns are written in place.
+var clientWriteColumns map[string]string
+
+// parseClientColumnMap parses the CLIENT_COLUMN_MAP spec into read -> write column.
+func parseClientColumnMap(spec string) (map[string]string, error) {
+	cols := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		read, write, ok := strings.Cut(entry, ":")
+		read, write = strings.TrimSpace(read), strings.TrimSpace(write)
+		if !ok || !isSQLIdentifier(read) || !isSQLIdentifier(write) {
+			return nil, &ConfigError{Key: "CLIENT_COLUMN_MAP", Err: fmt.Errorf("entry %q must be read_column:write_column", entry)}
+		}
+		if !isClientAttachmentColumn(read) {
+			return nil, &ConfigError{Key: "CLIENT_COLUMN_MAP", Err: fmt.Errorf("%q is not a client attachment column", read)}
+		}
+		cols[read] = write
+	}
+	return cols, nil
+}
+
+func isClientAttachmentColumn(col string) bool {
+	for _, c := range clientAttachmentColumns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// clientWriteColumn returns the column the cleaned value of read column col is written to.
+func clientWriteColumn(col string) string {
+	if w, ok := clientWriteColumns[col]; ok {
+		return w
+	}
+	return col
+}
+
+// clientSelectColumns lists the client columns to select: the attachment columns plus, for every
+// mapped one, its write column aliased as written_<read column> (see ClientRow.writtenValue).
+func clientSelectColumns() []string {
+	cols := append([]string(nil), clientAttachmentColumns...)
+	for _, read := range clientAttachmentColumns {
+		if write, ok := clientWriteColumns[read]; ok {
+			cols = append(cols, write+" AS written_"+read)
+		}
+	}
+	return cols
+}
+
+// validateClientColumnMap checks at startup that every mapped write column exists.
+func validateClientColumnMap(ctx context.Context, db *sqlx.DB) error {
+	if len(clientWriteColumns) == 0 {
+		return nil
+	}
+	cols, err := sqlDialect.tableColumns(ctx, db, "client")
+	if err != nil {
+		return &DBError{Op: "inspect client", Err: err}
+	}
+	for _, write := range clientWriteColumns {
+		if !cols[write] {
+			return &ConfigError{Key: "CLIENT_COLUMN_MAP", Err: fmt.Errorf("column client.%s does not exist", write)}
+		}
+	}
+	return nil
+}
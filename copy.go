@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Copy mode (COPY_MODE)
+// ------------------------------
+
+// copyMode (COPY_MODE=1) leaves the source tables untouched: every cleaned row is written as
+// (pk, URL columns) to <table>_cleaned instead, to be swapped in manually. Columns a row did not
+// change are copied from the source, so <table>_cleaned holds complete values. Re-runs replace
+// the copies, and no rollback SQL is written since nothing is updated in place.
+var copyMode bool
+
+// cleanedTable is the COPY_MODE target of table.
+func cleanedTable(table string) string {
+	return table + "_cleaned"
+}
+
+// copyColumns returns the pk column and the URL columns of table that COPY_MODE writes.
+func copyColumns(table string) (pkCol string, cols []string, err error) {
+	switch table {
+	case "bulk":
+		return "id", []string{"archive_file"}, nil
+	case "partner":
+		return "partner_id", []string{"meta"}, nil
+	case "client":
+		for _, c := range clientAttachmentColumns {
+			cols = append(cols, clientWriteColumn(c))
+		}
+		return "client_id", cols, nil
+	}
+	for _, t := range genericTables {
+		if t.Table == table {
+			return t.PKColumn, []string{t.URLColumn}, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no COPY_MODE columns for table %q", table)
+}
+
+// ensureCleanedTable creates <table>_cleaned with the copy columns of table if it does not exist.
+func ensureCleanedTable(ctx context.Context, db *sqlx.DB, table string) error {
+	if table == "client" && len(clientAttachmentColumns) == 0 {
+		return nil
+	}
+	pkCol, cols, err := copyColumns(table)
+	if err != nil {
+		return err
+	}
+	return sqlDialect.createCopyTable(ctx, db, cleanedTable(table), table, pkCol, cols)
+}
+
+// writeCleanedRow writes row pk of table to <table>_cleaned with values (column -> new value)
+// replacing the source values.
+func writeCleanedRow(ctx context.Context, db sqlx.ExecerContext, table string, pk interface{}, values map[string]string) error {
+	pkCol, cols, err := copyColumns(table)
+	if err != nil {
+		return err
+	}
+	sel := []string{pkCol}
+	var args []interface{}
+	for _, c := range cols {
+		if v, ok := values[c]; ok {
+			sel = append(sel, "?")
+			args = append(args, v)
+		} else {
+			sel = append(sel, c)
+		}
+	}
+	args = append(args, pk)
+
+	query := fmt.Sprintf("REPLACE INTO %s (%s, %s) SELECT %s FROM %s WHERE %s = ?",
+		cleanedTable(table), pkCol, strings.Join(cols, ", "), strings.Join(sel, ", "), table, pkCol)
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return &DBError{Op: "copy " + table, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ------------------------------
+// Row context on error (DEBUG_ON_ERROR)
+// ------------------------------
+
+// debugOnError (DEBUG_ON_ERROR=1) logs the full context of every failed row: all fetched
+// columns and the computed new value, if the error happened after cleaning. Signature/token
+// query params are redacted. The normal path logs nothing extra.
+var debugOnError bool
+
+// secretParamRegex matches the value of query params that carry credentials, inside plain URLs
+// and JSON-encoded ones alike.
+var secretParamRegex = regexp.MustCompile(`(?i)([?&](?:signature|sig|token|access_token|x-amz-signature|x-amz-credential|x-amz-security-token)=)[^&"\\\s]*`)
+
+// redactSecrets replaces credential query param values in s.
+func redactSecrets(s string) string {
+	return secretParamRegex.ReplaceAllString(s, "${1}REDACTED")
+}
+
+// newValueError carries the value a row would have been updated to when the update failed.
+type newValueError struct {
+	newValue interface{}
+	err      error
+}
+
+func (e *newValueError) Error() string { return e.err.Error() }
+func (e *newValueError) Unwrap() error { return e.err }
+
+// withNewValue attaches newValue to err for the DEBUG_ON_ERROR dump.
+func withNewValue(err error, newValue interface{}) error {
+	return &newValueError{newValue: newValue, err: err}
+}
+
+// logRowContext dumps the row behind err as one [DEBUG] line when DEBUG_ON_ERROR=1.
+func logRowContext(label string, columns map[string]interface{}, err error) {
+	if !debugOnError {
+		return
+	}
+	ctx := map[string]interface{}{"columns": columns, "error": err.Error()}
+	var nv *newValueError
+	if errors.As(err, &nv) {
+		ctx["new_value"] = nv.newValue
+	}
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false) // keep & literal so redaction sees the query separators
+	if mErr := enc.Encode(ctx); mErr != nil {
+		log.Printf("[%s][DEBUG] row context unavailable: %v", label, mErr)
+		return
+	}
+	log.Printf("[%s][DEBUG] row context: %s", label, redactSecrets(strings.TrimSpace(b.String())))
+}
+
+// nullableValue is v for JSON output: the string, or nil for NULL.
+func nullableValue(valid bool, s string) interface{} {
+	if !valid {
+		return nil
+	}
+	return s
+}
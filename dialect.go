@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+// ------------------------------
+// SQL dialects (DB_DRIVER)
+// ------------------------------
+
+// dialect holds the SQL fragments that differ between the supported drivers.
+// MySQL is the production default; SQLite exists for fast local runs.
+type dialect struct {
+	// driver is the database/sql driver name passed to sqlx.Open.
+	driver string
+}
+
+var (
+	mysqlDialect  = dialect{driver: "mysql"}
+	sqliteDialect = dialect{driver: "sqlite"}
+)
+
+// sqlDialect is the dialect selected by DB_DRIVER.
+var sqlDialect = mysqlDialect
+
+func init() {
+	// modernc.org/sqlite registers as "sqlite", which sqlx does not know; it uses ? placeholders.
+	sqlx.BindDriver(sqliteDialect.driver, sqlx.QUESTION)
+}
+
+// parseDialect maps DB_DRIVER to a dialect; empty means MySQL.
+func parseDialect(name string) (dialect, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "mysql":
+		return mysqlDialect, nil
+	case "sqlite":
+		return sqliteDialect, nil
+	default:
+		return dialect{}, &ConfigError{Key: "DB_DRIVER", Err: fmt.Errorf("unsupported driver %q (want mysql or sqlite)", name)}
+	}
+}
+
+func (d dialect) isSQLite() bool {
+	return d.driver == sqliteDialect.driver
+}
+
+// now is the current timestamp expression.
+func (d dialect) now() string {
+	if d.isSQLite() {
+		return "datetime('now')"
+	}
+	return "NOW()"
+}
+
+// monthAgo is the timestamp expression for one month before now.
+func (d dialect) monthAgo() string {
+	if d.isSQLite() {
+		return "datetime('now', '-1 month')"
+	}
+	return "DATE_SUB(NOW(), INTERVAL 1 MONTH)"
+}
+
+// insertIgnore is the INSERT variant that silently skips duplicate keys.
+func (d dialect) insertIgnore() string {
+	if d.isSQLite() {
+		return "INSERT OR IGNORE"
+	}
+	return "INSERT IGNORE"
+}
+
+// createTableLike creates dst with the same structure (including keys) as src if it does not exist.
+func (d dialect) createTableLike(ctx context.Context, db *sqlx.DB, dst, src string) error {
+	if !d.isSQLite() {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s LIKE %s", dst, src))
+		return err
+	}
+
+	// SQLite has no CREATE TABLE ... LIKE; replay the source DDL under the new name.
+	var ddl string
+	if err := db.GetContext(ctx, &ddl, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, src); err != nil {
+		return err
+	}
+	idx := strings.Index(ddl, "(")
+	if idx < 0 {
+		return fmt.Errorf("unexpected DDL for %s: %q", src, ddl)
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s %s", dst, ddl[idx:]))
+	return err
+}
+
+// createCopyTable creates dst with the pk and cols of src (types only, no rows) if it does not
+// exist, keyed on pk so rows can be replaced.
+func (d dialect) createCopyTable(ctx context.Context, db *sqlx.DB, dst, src, pk string, cols []string) error {
+	selectList := pk + ", " + strings.Join(cols, ", ")
+	if !d.isSQLite() {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (PRIMARY KEY (%s)) SELECT %s FROM %s WHERE 1 = 0",
+			dst, pk, selectList, src))
+		return err
+	}
+
+	// SQLite cannot declare keys in CREATE TABLE ... AS; add a unique index instead.
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS SELECT %s FROM %s WHERE 0", dst, selectList, src)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_pk ON %s (%s)", dst, dst, pk))
+	return err
+}
+
+// createProgressTable creates the PROGRESS_TABLE table if it does not exist.
+func (d dialect) createProgressTable(ctx context.Context, db *sqlx.DB, table string) error {
+	ddl := `CREATE TABLE IF NOT EXISTS %s (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    run_id VARCHAR(64) NOT NULL,
+    table_name VARCHAR(128) NOT NULL,
+    batch_num INT NOT NULL,
+    id_range VARCHAR(512) NOT NULL,
+    rows_updated INT NOT NULL,
+    committed_at DATETIME NOT NULL,
+    KEY idx_run_id (run_id)
+)`
+	if d.isSQLite() {
+		ddl = `CREATE TABLE IF NOT EXISTS %s (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    batch_num INTEGER NOT NULL,
+    id_range TEXT NOT NULL,
+    rows_updated INTEGER NOT NULL,
+    committed_at TEXT NOT NULL
+)`
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(ddl, table))
+	return err
+}
+
+// quoteString returns s as a string literal for this dialect.
+func (d dialect) quoteString(s string) string {
+	if d.isSQLite() {
+		// SQLite has no backslash escapes; only single quotes need doubling.
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return quoteSQLString(s)
+}
+
+// tableColumns returns the set of column names of table in the current database.
+func (d dialect) tableColumns(ctx context.Context, db *sqlx.DB, table string) (map[string]bool, error) {
+	var names []string
+	var err error
+	if d.isSQLite() {
+		err = db.SelectContext(ctx, &names, `SELECT name FROM pragma_table_info(?)`, table)
+	} else {
+		err = db.SelectContext(ctx, &names, `
+SELECT COLUMN_NAME
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+`, table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]bool, len(names))
+	for _, n := range names {
+		cols[n] = true
+	}
+	return cols, nil
+}
+
+// columnMaxLengths returns the declared character length of every length-limited column of
+// table. SQLite does not enforce lengths; its VARCHAR(n) declarations are read so local runs
+// behave like MySQL.
+func (d dialect) columnMaxLengths(ctx context.Context, db *sqlx.DB, table string) (map[string]int, error) {
+	lengths := make(map[string]int)
+	if d.isSQLite() {
+		var cols []struct {
+			Name string `db:"name"`
+			Type string `db:"type"`
+		}
+		if err := db.SelectContext(ctx, &cols, `SELECT name, type FROM pragma_table_info(?)`, table); err != nil {
+			return nil, err
+		}
+		for _, c := range cols {
+			var n int
+			if _, err := fmt.Sscanf(strings.ToUpper(c.Type), "VARCHAR(%d)", &n); err == nil && n > 0 {
+				lengths[c.Name] = n
+			}
+		}
+		return lengths, nil
+	}
+
+	var cols []struct {
+		Name   string `db:"COLUMN_NAME"`
+		Length int64  `db:"CHARACTER_MAXIMUM_LENGTH"`
+	}
+	// TEXT types report lengths of 65535 and up, which no URL reaches; only VARCHAR/CHAR matter.
+	if err := db.SelectContext(ctx, &cols, `
+SELECT COLUMN_NAME, CHARACTER_MAXIMUM_LENGTH
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND DATA_TYPE IN ('varchar', 'char')
+`, table); err != nil {
+		return nil, err
+	}
+	for _, c := range cols {
+		lengths[c.Name] = int(c.Length)
+	}
+	return lengths, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ------------------------------
+// Multiple databases (DB_DSNS)
+// ------------------------------
+
+// DB_DSNS="dsn1,dsn2" runs the selected migrations against each database in turn (e.g. one MySQL
+// instance per region with the same schema), with a summary per DSN and a combined total. A
+// failing DSN does not stop the others unless DB_DSNS_STOP_ON_ERROR=1. MAX_WRITES, the report
+// and the rollback script are shared by all of them; report records carry the DSN's host/db as
+// "dsn" and the rollback script has a "-- dsn:" line before each DSN's statements.
+var dsnsStopOnError bool
+
+// dsnResults are the per-DSN outcomes of a DB_DSNS run, for the changelog.
+var dsnResults []dsnResult
+
+// currentDSN is the host/db of the database being migrated when DB_DSNS lists several, else "".
+var currentDSN string
+
+// loadDSNs returns the DSNs to run against and the env var they came from.
+func loadDSNs() ([]string, string, error) {
+	dsn := os.Getenv("DB_DSN")
+	spec := strings.TrimSpace(os.Getenv("DB_DSNS"))
+	if spec == "" {
+		if dsn == "" {
+			return nil, "", &ConfigError{Key: "DB_DSN", Err: errors.New("env or -dsn flag is required")}
+		}
+		return []string{dsn}, "DB_DSN", nil
+	}
+	if dsn != "" {
+		return nil, "", &ConfigError{Key: "DB_DSNS", Err: errors.New("set DB_DSN or DB_DSNS, not both")}
+	}
+
+	var dsns []string
+	for _, d := range strings.Split(spec, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			dsns = append(dsns, d)
+		}
+	}
+	if len(dsns) == 0 {
+		return nil, "", &ConfigError{Key: "DB_DSNS", Err: fmt.Errorf("no DSNs in %q", spec)}
+	}
+	if len(dsns) > 1 {
+		if runMode != modeMigrate {
+			return nil, "", &ConfigError{Key: "DB_DSNS", Err: fmt.Errorf("MODE=%s runs against a single DSN", runMode)}
+		}
+		if os.Getenv("REPL_LAG_DSN") != "" {
+			return nil, "", &ConfigError{Key: "DB_DSNS", Err: errors.New("cannot be combined with REPL_LAG_DSN (one replica for several primaries)")}
+		}
+	}
+	dsnsStopOnError = os.Getenv("DB_DSNS_STOP_ON_ERROR") == "1"
+	return dsns, "DB_DSNS", nil
+}
+
+// dsnLabel is the host/db of a MySQL DSN for logs and reports, never its credentials. SQLite
+// DSNs are file paths and shown as is.
+func dsnLabel(dsn string) string {
+	if sqlDialect.isSQLite() {
+		return dsn
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "(unparseable)"
+	}
+	return cfg.Addr + "/" + cfg.DBName
+}
+
+// dsnResult is the outcome of the migrations against one DSN.
+type dsnResult struct {
+	label  string
+	totals migrationSummary
+	err    error
+}
+
+// runDSNs runs the migrations against every DSN in turn. adaptToSchema trims the configured
+// columns and tables to each schema, so they are restored before every DSN.
+func runDSNs(ctx context.Context, dsns []string, dryRun bool, batchSize int) error {
+	if len(dsns) == 1 {
+		return runDSN(ctx, dsns[0], dryRun, batchSize)
+	}
+
+	var (
+		baseClientColumns = append([]string(nil), clientAttachmentColumns...)
+		baseGenericTables = append([]genericTable(nil), genericTables...)
+		baseHTMLColumns   = append([]genericTable(nil), htmlColumns...)
+		basePrefilter     = partnerJSONPrefilter
+		baseTagParams     = append([]string(nil), tagParams...)
+	)
+	var results []dsnResult
+	for i, dsn := range dsns {
+		clientAttachmentColumns = append([]string(nil), baseClientColumns...)
+		genericTables = append([]genericTable(nil), baseGenericTables...)
+		htmlColumns = append([]genericTable(nil), baseHTMLColumns...)
+		partnerJSONPrefilter = basePrefilter
+		tagParams = append([]string(nil), baseTagParams...)
+		currentDSN = dsnLabel(dsn)
+
+		log.Printf("==== DSN %d/%d: %s ====", i+1, len(dsns), currentDSN)
+		before := summaryTotals()
+		err := runDSN(ctx, dsn, dryRun, batchSize)
+		after := summaryTotals()
+		results = append(results, dsnResult{
+			label: currentDSN,
+			totals: migrationSummary{
+				rows:        after.rows - before.rows,
+				updated:     after.updated - before.updated,
+				skipped:     after.skipped - before.skipped,
+				urlsCleaned: after.urlsCleaned - before.urlsCleaned,
+			},
+			err: err,
+		})
+		if err != nil {
+			log.Printf("[DSN][ERROR] %s: %v", currentDSN, err)
+			logErrorJSON("dsn_run", map[string]interface{}{"dsn": currentDSN}, err)
+			if dsnsStopOnError {
+				break
+			}
+		}
+		if ctx.Err() != nil || writeLimitReached() {
+			break
+		}
+	}
+	currentDSN = ""
+	dsnResults = results
+
+	var total migrationSummary
+	var failed []error
+	for _, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = "failed"
+			failed = append(failed, fmt.Errorf("%s: %w", r.label, r.err))
+		}
+		log.Printf("[DSN][SUMMARY] %s status=%s totalRows=%d totalUpdated=%d totalSkipped=%d urlsCleaned=%d",
+			r.label, status, r.totals.rows, r.totals.updated, r.totals.skipped, r.totals.urlsCleaned)
+		total.rows += r.totals.rows
+		total.updated += r.totals.updated
+		total.skipped += r.totals.skipped
+		total.urlsCleaned += r.totals.urlsCleaned
+	}
+	log.Printf("[DSN][SUMMARY] dsns=%d/%d failed=%d totalRows=%d totalUpdated=%d totalSkipped=%d urlsCleaned=%d",
+		len(results), len(dsns), len(failed), total.rows, total.updated, total.skipped, total.urlsCleaned)
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d DSNs failed: %w", len(failed), len(dsns), errors.Join(failed...))
+	}
+	return nil
+}
+
+// writeRollbackDSN marks the start of the current DSN's statements in the rollback script.
+func writeRollbackDSN() {
+	if rollbackSQLFile == nil || currentDSN == "" {
+		return
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if _, err := rollbackSQLFile.WriteString("-- dsn: " + currentDSN + "\n"); err != nil {
+		log.Printf("[WARN] failed to write rollback SQL DSN line: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ------------------------------
+// Typed errors
+// ------------------------------
+
+// ConfigError reports an invalid or missing configuration value.
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string { return fmt.Sprintf("config %s: %v", e.Key, e.Err) }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// DBError reports a failed database operation (Op is e.g. "select bulk", "update client").
+type DBError struct {
+	Op  string
+	Err error
+}
+
+func (e *DBError) Error() string { return fmt.Sprintf("db %s: %v", e.Op, e.Err) }
+func (e *DBError) Unwrap() error { return e.Err }
+
+// URLParseError reports a stored value that could not be parsed as a URL.
+type URLParseError struct {
+	URL string
+	Err error
+}
+
+func (e *URLParseError) Error() string { return fmt.Sprintf("parse url %q: %v", e.URL, e.Err) }
+func (e *URLParseError) Unwrap() error { return e.Err }
+
+// parseURL is url.Parse returning a *URLParseError on failure.
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, &URLParseError{URL: rawURL, Err: err}
+	}
+	return u, nil
+}
+
+// Process exit codes, chosen by the kind of error that stopped the run.
+const (
+	exitGeneric     = 1
+	exitConfig      = 2
+	exitDB          = 3
+	exitPostCheck   = 4
+	exitInterrupted = 130
+)
+
+// exitCode maps err to a process exit code so wrappers can tell config, DB and interrupt failures apart.
+func exitCode(err error) int {
+	var (
+		cfgErr *ConfigError
+		dbErr  *DBError
+	)
+	switch {
+	case errors.Is(err, errPostCheckFailed):
+		return exitPostCheck
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &cfgErr):
+		return exitConfig
+	case errors.As(err, &dbErr):
+		return exitDB
+	default:
+		return exitGeneric
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ------------------------------
+// Batch fetch retry (FETCH_RETRY_MAX)
+// ------------------------------
+
+// fetchRetryMax is how many times a failed batch SELECT is retried before the migration gives up
+// (FETCH_RETRY_MAX, default 0 = fail on the first error).
+var fetchRetryMax int
+
+// fetchRetryBaseDelay / fetchRetryMaxDelay bound the exponential backoff between fetch attempts.
+const (
+	fetchRetryBaseDelay = 500 * time.Millisecond
+	fetchRetryMaxDelay  = 10 * time.Second
+)
+
+// fetchWithRetry runs fetch and retries it up to fetchRetryMax times with exponential backoff.
+// Re-running a batch SELECT is always safe: it is read-only and keyset-paginated from the same
+// lastID, so a retry reads the same batch again. Cancellation is never retried; after the last
+// attempt the error of that attempt is returned.
+func fetchWithRetry[R any](ctx context.Context, label string, fetch func() ([]R, error)) ([]R, error) {
+	delay := fetchRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		rows, err := fetch()
+		if err == nil || attempt >= fetchRetryMax || ctx.Err() != nil {
+			return rows, err
+		}
+		log.Printf("[%s][WARN] fetch batch failed (attempt %d/%d), retrying in %s: %v",
+			label, attempt+1, fetchRetryMax+1, delay, err)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		delay = min(delay*2, fetchRetryMaxDelay)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Eligibility filter columns
+// ------------------------------
+
+// filterColumn is one column of the partner/client eligibility filters whose name differs
+// between schema versions. The name comes from Env (default Default).
+type filterColumn struct {
+	Table   string
+	Env     string
+	Default string
+	Name    string
+}
+
+// Columns of the partner/client eligibility filters: banned rows and expired contracts are
+// never touched. Each name is overridable for schemas that call them differently.
+var (
+	partnerBannedColumn      = &filterColumn{Table: "partner", Env: "PARTNER_BANNED_COLUMN", Default: "partner_is_banned"}
+	partnerContractEndColumn = &filterColumn{Table: "partner", Env: "PARTNER_CONTRACT_END_COLUMN", Default: "partner_contract_end"}
+	clientBannedColumn       = &filterColumn{Table: "client", Env: "CLIENT_BANNED_COLUMN", Default: "client_is_banned"}
+	clientContractEndColumn  = &filterColumn{Table: "client", Env: "CLIENT_CONTRACT_END_COLUMN", Default: "client_contract_end_date"}
+
+	filterColumns = []*filterColumn{partnerBannedColumn, partnerContractEndColumn, clientBannedColumn, clientContractEndColumn}
+)
+
+// loadFilterColumns reads the filter column names from the env. Names are interpolated into
+// the WHERE clauses, so only plain identifiers are accepted.
+func loadFilterColumns() error {
+	for _, c := range filterColumns {
+		c.Name = c.Default
+		if v := strings.TrimSpace(os.Getenv(c.Env)); v != "" {
+			if !isSQLIdentifier(v) {
+				return &ConfigError{Key: c.Env, Err: fmt.Errorf("invalid column name %q", v)}
+			}
+			c.Name = v
+		}
+	}
+	return nil
+}
+
+// eligibleSQL is the banned/contract-end condition of table's rows that may be touched, or ""
+// for tables without one. The marker and URL conditions are not part of it.
+func eligibleSQL(table string) string {
+	switch table {
+	case "partner":
+		return partnerBannedColumn.Name + " != 1 AND " + partnerContractEndColumn.Name + " >= " + sqlDialect.now()
+	case "client":
+		return clientBannedColumn.Name + " != 1 AND " + clientContractEndColumn.Name + " >= " + sqlDialect.now()
+	}
+	return ""
+}
+
+// filtersTable reports whether a migration that will run reads table through its eligibility
+// filter: the table itself or one of its HTML_COLUMNS.
+func filtersTable(table string) bool {
+	if runsTable(table) {
+		return true
+	}
+	for _, t := range htmlColumns {
+		if t.Table == table && runsTable(t.name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFilterColumns checks at startup that every filter column exists in its table, so a
+// misnamed column fails the run instead of every batch. Tables that will not run are skipped.
+func validateFilterColumns(ctx context.Context, db *sqlx.DB) error {
+	for _, c := range filterColumns {
+		if !filtersTable(c.Table) {
+			continue
+		}
+		cols, err := sqlDialect.tableColumns(ctx, db, c.Table)
+		if err != nil {
+			return &DBError{Op: "inspect " + c.Table, Err: err}
+		}
+		if !cols[c.Name] {
+			return &ConfigError{Key: c.Env, Err: fmt.Errorf("column %s.%s does not exist", c.Table, c.Name)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ------------------------------
+// Command-line flags
+// ------------------------------
+
+// cliFlags are the flags for ad hoc runs. Each one overrides an env var, so the precedence is
+// flag > env > .env > default and everything downstream keeps reading the environment.
+var cliFlags = []struct {
+	name, env, usage string
+	isBool           bool
+}{
+	{"tags", "TAG_PARAMS", "comma-separated query params to remove", false},
+	{"prefixes", "STORAGE_PREFIXES", "comma-separated storage prefixes stripped before cleaning", false},
+	{"tables", "TABLES", "comma-separated migrations to run (default all)", false},
+	{"batch-size", "BATCH_SIZE", "rows per batch", false},
+	{"dry-run", "DRY_RUN", "log changes without writing", true},
+	{"dsn", "DB_DSN", "database DSN", false},
+}
+
+// flagSetEnvs holds the env vars set from flags, for reporting where a value came from.
+var flagSetEnvs = make(map[string]bool)
+
+// applyFlags parses args and copies every flag given into its env var. -h prints the usage and
+// returns flag.ErrHelp.
+func applyFlags(args []string) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	values := make(map[string]*string)
+	bools := make(map[string]*bool)
+	for _, f := range cliFlags {
+		usage := fmt.Sprintf("%s (overrides %s)", f.usage, f.env)
+		if f.isBool {
+			bools[f.name] = fs.Bool(f.name, false, usage)
+		} else {
+			values[f.name] = fs.String(f.name, "", usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &ConfigError{Key: "flags", Err: fmt.Errorf("unexpected arguments %q", fs.Args())}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, f := range cliFlags {
+		if !set[f.name] {
+			continue
+		}
+		v := ""
+		if f.isBool {
+			v = "0"
+			if *bools[f.name] {
+				v = "1"
+			}
+		} else {
+			v = *values[f.name]
+		}
+		os.Setenv(f.env, v)
+		flagSetEnvs[f.env] = true
+	}
+	return nil
+}
+
+// valueSource names where the value of env came from: flag, env, .env or default.
+func valueSource(env string) string {
+	switch {
+	case flagSetEnvs[env]:
+		return "flag"
+	case dotEnvKeys[env] && strings.TrimSpace(os.Getenv(env)) != "":
+		return ".env"
+	case strings.TrimSpace(os.Getenv(env)) != "":
+		return "env"
+	default:
+		return "default"
+	}
+}
+
+// logResolvedConfig prints the effective value and source of every flag-backed setting.
+func logResolvedConfig(dsnKey string, dsns []string, dryRun bool, batchSize int) {
+	var tables []string
+	for _, name := range migrationOrder {
+		if runsTable(name) {
+			tables = append(tables, name)
+		}
+	}
+	prefixes := strings.Join(storagePrefixes, ",")
+	if prefixes == "" {
+		prefixes = "none"
+	}
+	redacted := make([]string, 0, len(dsns))
+	for _, dsn := range dsns {
+		redacted = append(redacted, redactDSN(dsn))
+	}
+	log.Printf("config: tags=%s (%s) prefixes=%s (%s) tables=%s (%s) batch-size=%d (%s) dry-run=%v (%s) dsn=%s (%s)",
+		strings.Join(tagParams, ","), valueSource("TAG_PARAMS"),
+		prefixes, valueSource("STORAGE_PREFIXES"),
+		strings.Join(tables, ","), valueSource("TABLES"),
+		batchSize, valueSource("BATCH_SIZE"),
+		dryRun, valueSource("DRY_RUN"),
+		strings.Join(redacted, ","), valueSource(dsnKey))
+}
+
+// redactDSN hides the password of a MySQL DSN. SQLite DSNs are file paths and shown as is.
+func redactDSN(dsn string) string {
+	if sqlDialect.isSQLite() {
+		return dsn
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "(unparseable)"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = "REDACTED"
+	}
+	return cfg.FormatDSN()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unsetEnv clears key for the test and restores it afterwards.
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+}
+
+func TestFlagsBeatDotEnv(t *testing.T) {
+	unsetEnv(t, "DRY_RUN", "TABLES", "BATCH_SIZE", "DB_DSN")
+	t.Cleanup(func() {
+		flagSetEnvs = make(map[string]bool)
+		dotEnvKeys = make(map[string]bool)
+	})
+	dir := t.TempDir()
+	env := "DRY_RUN=0\nTABLES=client\nBATCH_SIZE=50\n"
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(env), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyFlags([]string{"-dry-run", "-tables", "bulk"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadDotEnvFile(filepath.Join(dir, ".env")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ key, want, source string }{
+		{"DRY_RUN", "1", "flag"},
+		{"TABLES", "bulk", "flag"},
+		{"BATCH_SIZE", "50", ".env"},
+		{"DB_DSN", "", "default"},
+	} {
+		if got := os.Getenv(tc.key); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.key, got, tc.want)
+		}
+		if got := valueSource(tc.key); got != tc.source {
+			t.Errorf("valueSource(%s) = %q, want %q", tc.key, got, tc.source)
+		}
+	}
+}
+
+func TestEnvBeatsDotEnv(t *testing.T) {
+	t.Setenv("BATCH_SIZE", "10")
+	t.Cleanup(func() { dotEnvKeys = make(map[string]bool) })
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("BATCH_SIZE=50\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadDotEnvFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("BATCH_SIZE"); got != "10" {
+		t.Errorf("BATCH_SIZE = %q, want 10", got)
+	}
+	if got := valueSource("BATCH_SIZE"); got != "env" {
+		t.Errorf("valueSource = %q, want env", got)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// GENERIC: config-driven plain-URL tables
+// ------------------------------
+
+// genericTable describes a table with a single plain-URL column, configured via
+// EXTRA_TABLES="table:pk_column:url_column[:string][,...]" (e.g. documents:doc_id:file_url).
+// No extra eligibility filters are applied beyond a non-empty URL. The pk is an integer unless
+// the entry ends in ":string" (e.g. UUID pks); string pks are paged in the column's own order.
+type genericTable struct {
+	Table     string
+	PKColumn  string
+	URLColumn string
+	StringPK  bool
+	// HTML marks an HTML_COLUMNS entry: URLColumn holds an HTML document whose links are cleaned.
+	HTML bool
+}
+
+// name is the migration name: the table, or table.column for an HTML column.
+func (t genericTable) name() string {
+	if t.HTML {
+		return t.Table + "." + t.URLColumn
+	}
+	return t.Table
+}
+
+// label is the log prefix for the table, e.g. DOCUMENTS.
+func (t genericTable) label() string {
+	return strings.ToUpper(t.name())
+}
+
+// markTable is the table whose MARK_COLUMN applies. HTML columns ignore the marker, which
+// tracks the table's own migration.
+func (t genericTable) markTable() string {
+	if t.HTML {
+		return ""
+	}
+	return t.Table
+}
+
+// firstPK is the cursor before the first batch: 0, or "" for string pks.
+func (t genericTable) firstPK() pkValue {
+	if t.StringPK {
+		return stringPK("")
+	}
+	return intPK(0)
+}
+
+// parsePK types a pk as read from the DB.
+func (t genericTable) parsePK(raw string) (pkValue, error) {
+	if t.StringPK {
+		return stringPK(raw), nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return pkValue{}, fmt.Errorf("%s.%s: non-integer pk %q (declare the table with :string)", t.Table, t.PKColumn, raw)
+	}
+	return intPK(n), nil
+}
+
+type GenericRow struct {
+	RawPK string         `db:"pk"`
+	URL   sql.NullString `db:"url"`
+	// PK is RawPK typed per genericTable.StringPK, set by fetchGenericBatch.
+	PK pkValue `db:"-"`
+}
+
+var genericTables []genericTable
+
+// migrationNames lists every migration: the tables (see allTables), then the HTML_COLUMNS.
+func migrationNames() []string {
+	names := allTables()
+	for _, t := range htmlColumns {
+		names = append(names, t.name())
+	}
+	return names
+}
+
+// allTables lists every table the tool can migrate: the built-in ones, then EXTRA_TABLES.
+func allTables() []string {
+	tables := []string{"bulk", "partner", "client"}
+	for _, t := range genericTables {
+		tables = append(tables, t.Table)
+	}
+	return tables
+}
+
+// parseGenericTables parses the EXTRA_TABLES spec. Every name must be a plain SQL identifier.
+func parseGenericTables(spec string) ([]genericTable, error) {
+	var tables []genericTable
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		stringPK := false
+		if len(parts) == 4 {
+			switch strings.TrimSpace(parts[3]) {
+			case "string":
+				stringPK = true
+			case "int":
+			default:
+				return nil, &ConfigError{Key: "EXTRA_TABLES", Err: fmt.Errorf("entry %q: pk type must be int or string", entry)}
+			}
+			parts = parts[:3]
+		}
+		if len(parts) != 3 {
+			return nil, &ConfigError{Key: "EXTRA_TABLES", Err: fmt.Errorf("entry %q must be table:pk_column:url_column[:string]", entry)}
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+			if !isSQLIdentifier(parts[i]) {
+				return nil, &ConfigError{Key: "EXTRA_TABLES", Err: fmt.Errorf("entry %q: invalid identifier %q", entry, parts[i])}
+			}
+		}
+		tables = append(tables, genericTable{Table: parts[0], PKColumn: parts[1], URLColumn: parts[2], StringPK: stringPK})
+	}
+	return tables, nil
+}
+
+func migrateGenericRemoveTag(ctx context.Context, db *sqlx.DB, t genericTable, dryRun bool, batchSize int) error {
+	label := t.label()
+	log.Printf("== %s: start remove tagging in %s ==", label, t.URLColumn)
+
+	var (
+		batchNum     int
+		totalRows    int
+		totalUpdated int
+		totalSkipped int
+		stoppedAt    pkValue
+		stopped      bool
+	)
+	lastID := t.firstPK()
+	if _, ok := shardOf(ctx); ok {
+		lastID = intPK(shardStart(ctx))
+	}
+	stats := newMigrationStats()
+	// Rows already started finish their write even if ctx is cancelled; the loop stops at the next check.
+	rowCtx := context.WithoutCancel(ctx)
+	btx := newBatchTx(db, label)
+	var bspan batchSpan
+	defer bspan.abort()
+
+batches:
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+		waitWhilePaused(ctx, label)
+		waitForReplication(ctx, label)
+		if ctx.Err() != nil {
+			break
+		}
+
+		rows, err := fetchWithRetry(ctx, label, func() ([]GenericRow, error) {
+			return fetchGenericBatch(ctx, db, t, lastID, batchSize)
+		})
+		if err != nil {
+			logErrorJSON("generic_fetch_batch", map[string]interface{}{
+				"table":      t.Table,
+				"last_pk":    lastID,
+				"batch_size": batchSize,
+			}, err)
+			return fmt.Errorf("fetch %s batch: %w", t.Table, err)
+		}
+		if len(rows) == 0 {
+			log.Printf("[%s] no more rows after %s=%s, stopping", label, t.PKColumn, lastID)
+			break
+		}
+
+		batchNum++
+		log.Printf("[%s] batch #%d, size=%d, %s range %s..%s",
+			label, batchNum, len(rows), t.PKColumn, rows[0].PK, rows[len(rows)-1].PK)
+		updatedBefore := totalUpdated
+		bspan.start(ctx, t.name(), batchNum, rows[0].PK, rows[len(rows)-1].PK, len(rows))
+
+		if shadowApply {
+			ids := make([]pkValue, 0, len(rows))
+			for _, r := range rows {
+				ids = append(ids, r.PK)
+			}
+			if err := copyToShadow(ctx, db, t.Table, t.PKColumn, ids); err != nil {
+				return fmt.Errorf("copy %s batch to shadow: %w", t.Table, err)
+			}
+		}
+
+		if err := btx.begin(rowCtx); err != nil {
+			return fmt.Errorf("begin %s batch: %w", t.Table, err)
+		}
+		for i, r := range rows {
+			if i%ctxCheckEvery == 0 && ctx.Err() != nil {
+				log.Printf("[%s] interrupted mid-batch, last processed %s=%s", label, t.PKColumn, lastID)
+				break batches
+			}
+			totalRows++
+			lastID = r.PK
+
+			if !stats.inCanary(r.PK) {
+				totalSkipped++
+				continue
+			}
+
+			updated, skipped, err := processGenericRowRemoveTag(btx.rowContext(rowCtx), btx.exec(), t, r, stats, dryRun)
+			if errors.Is(err, errMaxWritesReached) {
+				totalRows--
+				log.Printf("[%s] MAX_WRITES=%d reached, stopping at %s=%s (not processed)", label, maxWrites, t.PKColumn, r.PK)
+				stoppedAt, stopped = r.PK, true
+				break batches
+			}
+			if err != nil {
+				log.Printf("[%s][ERROR] %s=%s: %v", label, t.PKColumn, r.PK, err)
+				bspan.recordError(err)
+				logErrorJSON("generic_process_row", map[string]interface{}{
+					"table":   t.Table,
+					"pk":      r.PK,
+					"dry_run": dryRun,
+				}, err)
+				logRowContext(label, map[string]interface{}{
+					t.PKColumn:  r.PK,
+					t.URLColumn: nullableValue(r.URL.Valid, r.URL.String),
+				}, err)
+				continue
+			}
+			if updated {
+				totalUpdated++
+				if err := btx.rowUpdated(rowCtx, r.PK); err != nil {
+					return err
+				}
+			}
+			if skipped {
+				totalSkipped++
+			}
+		}
+		if err := btx.commit(); err != nil {
+			return err
+		}
+		recordProgress(rowCtx, db, t.name(), batchNum, rows[0].PK, rows[len(rows)-1].PK, totalUpdated-updatedBefore, dryRun)
+		bspan.end(totalUpdated - updatedBefore)
+		sleepBetweenBatches(ctx, label)
+	}
+
+	if err := btx.commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[%s][SUMMARY] totalRows=%d totalUpdated=%d totalSkipped=%d", label, totalRows, totalUpdated, totalSkipped)
+	log.Printf("[%s][SUMMARY] urlsCleaned=%d rowsUpdated=%d (dryRun=%v)", label, stats.urlsCleaned, totalUpdated, dryRun)
+	recordSummary(t.name(), totalRows, totalUpdated, totalSkipped, stats.urlsCleaned)
+	stats.logSummary(ctx, label, t.PKColumn)
+
+	if stopped {
+		return fmt.Errorf("%s migration stopped at %s=%s: %w", t.Table, t.PKColumn, stoppedAt, errMaxWritesReached)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s migration interrupted after %s=%s: %w", t.Table, t.PKColumn, lastID, context.Cause(ctx))
+	}
+	return nil
+}
+
+func fetchGenericBatch(ctx context.Context, db *sqlx.DB, t genericTable, lastID pkValue, limit int) ([]GenericRow, error) {
+	var rows []GenericRow
+	if usesIDList(t.Table) {
+		// IDS_FILE lists integer pks only; loadConfig rejects it for string-pk tables.
+		if err := selectByIDs(ctx, db, &rows, t.Table, t.PKColumn, fmt.Sprintf("%s AS pk, %s AS url", t.PKColumn, t.URLColumn),
+			lastID.n, limit, func() int { return len(rows) }); err != nil {
+			return nil, err
+		}
+	} else {
+		query, args := genericBatchQuery(ctx, t, lastID, limit)
+		if err := db.SelectContext(ctx, &rows, query, args...); err != nil {
+			return nil, &DBError{Op: "select " + t.Table, Err: err}
+		}
+	}
+
+	for i := range rows {
+		pk, err := t.parsePK(rows[i].RawPK)
+		if err != nil {
+			return nil, err
+		}
+		rows[i].PK = pk
+	}
+	return clipToShard(ctx, rows, func(r GenericRow) int64 { return r.PK.n }), nil
+}
+
+// genericBatchQuery builds the candidate SELECT of t for the batch after lastID, within the shard
+// of ctx. An HTML column of partner or client only reads the rows its table's own migration may
+// touch.
+func genericBatchQuery(ctx context.Context, t genericTable, lastID pkValue, limit int) (string, []interface{}) {
+	shardSQL, shardArgs := shardEndSQL(ctx, t.PKColumn)
+	modifiedSQL, modifiedArgs := modifiedSinceSQL(t.Table)
+	eligible := ""
+	if cond := eligibleSQL(t.Table); cond != "" {
+		eligible = "\n    AND " + cond
+	}
+	query := fmt.Sprintf(`
+SELECT
+    %[2]s AS pk,
+    %[3]s AS url
+FROM %[1]s
+WHERE
+    %[2]s > ?%[7]s
+    AND %[3]s IS NOT NULL
+    AND %[3]s != ''%[6]s%[4]s%[5]s
+ORDER BY %[2]s ASC
+LIMIT ?
+`, t.Table, t.PKColumn, t.URLColumn, markFilterSQL(t.markTable()), modifiedSQL, eligible, shardSQL)
+	args := append(append([]interface{}{lastID}, shardArgs...), modifiedArgs...)
+	return query, append(args, limit)
+}
+
+func processGenericRowRemoveTag(
+	ctx context.Context,
+	db sqlx.ExtContext,
+	t genericTable,
+	row GenericRow,
+	stats *migrationStats,
+	dryRun bool,
+) (updated bool, skipped bool, err error) {
+	label := t.label()
+	if !row.URL.Valid {
+		return false, true, nil
+	}
+	raw := strings.TrimSpace(row.URL.String)
+	if raw == "" {
+		return false, true, nil
+	}
+
+	var (
+		newURL  string
+		removed []string
+	)
+	if t.HTML {
+		// The document is rewritten as a whole, whitespace included; host and URL filters
+		// do not apply to it.
+		raw = row.URL.String
+		newURL, _ = cleanHTML(raw)
+		removed = htmlRemovedTagPairs(raw, newURL)
+	} else {
+		stats.addRowHosts(raw)
+		stats.trackLongest(row.PK, raw)
+
+		if reason := urlFilterSkipReason(raw); reason != "" {
+			log.Printf("[%s][SKIP] %s=%s reason=%s", label, t.PKColumn, row.PK, reason)
+			stats.skip(reason)
+			return false, true, nil
+		}
+		if err := checkParseableURL(raw); err != nil {
+			log.Printf("[%s][SKIP] %s=%s reason=%s: %v", label, t.PKColumn, row.PK, skipUnparseableURL, err)
+			stats.skip(skipUnparseableURL)
+			quarantineRow(t.Table, t.PKColumn, row.PK, dryRun, skipUnparseableURL, err.Error(), map[string]string{t.URLColumn: row.URL.String})
+			return false, true, nil
+		}
+		if err := checkExpectedHost(raw); err != nil {
+			log.Printf("[%s][SKIP] %s=%s reason=%s: %v", label, t.PKColumn, row.PK, skipUnexpectedHost, err)
+			stats.skip(skipUnexpectedHost)
+			quarantineRow(t.Table, t.PKColumn, row.PK, dryRun, skipUnexpectedHost, err.Error(), map[string]string{t.URLColumn: row.URL.String})
+			return false, true, nil
+		}
+		newURL, _ = cleanURL(raw)
+		removed = removedTagPairs(raw, newURL)
+	}
+	if stats.alreadyClean(raw, newURL) {
+		return false, true, nil
+	}
+	if !t.HTML && resigns(t.Table, t.URLColumn) {
+		signed, err := resignURL(ctx, newURL)
+		if err != nil {
+			log.Printf("[%s][SKIP] %s=%s reason=%s err=%v", label, t.PKColumn, row.PK, skipResignFailed, err)
+			stats.skip(skipResignFailed)
+			return false, true, nil
+		}
+		newURL = signed
+	}
+
+	rec := newChangeRecord(t.Table, t.PKColumn, row.PK, dryRun)
+	rec.addColumn(t.URLColumn, row.URL.String, newURL, removed...)
+	if wouldTruncate(label, rec, stats) {
+		return false, true, nil
+	}
+
+	if dryRun {
+		stats.urlsCleaned++
+		stats.addChangedHosts(rec)
+		recordChange(rec)
+		if err := stats.verifyDryRunMatch(ctx, db, label, rec); err != nil {
+			return false, false, err
+		}
+		if !emitDryRunNDJSON(rec) {
+			log.Printf("[%s][DRY-RUN] %s=%s %s\nold=%s\nnew=%s", label, t.PKColumn, row.PK, t.URLColumn, raw, newURL)
+		}
+		return false, false, nil
+	}
+
+	if err := applyChange(ctx, rec, func() error { return updateGenericURL(ctx, db, t, row.PK, newURL) }); err != nil {
+		return false, false, withNewValue(fmt.Errorf("apply change: %w", err), newURL)
+	}
+
+	stats.urlsCleaned++
+	stats.addChangedHosts(rec)
+	log.Printf("[%s][OK] %s=%s updated %s\nold=%s\nnew=%s", label, t.PKColumn, row.PK, t.URLColumn, raw, newURL)
+	return true, false, nil
+}
+
+func updateGenericURL(ctx context.Context, db sqlx.ExecerContext, t genericTable, pk pkValue, newURL string) error {
+	query := fmt.Sprintf(`
+UPDATE %s
+SET %s = ?%s
+WHERE %s = ?
+`, targetTable(t.Table), t.URLColumn, markSetSQL(t.markTable()), t.PKColumn)
+	if err := reserveWrite(); err != nil {
+		return err
+	}
+	if copyMode {
+		return writeCleanedRow(ctx, db, t.Table, pk, map[string]string{t.URLColumn: newURL})
+	}
+	_, err := db.ExecContext(ctx, query, newURL, pk)
+	if err != nil {
+		return &DBError{Op: "update " + t.Table, Err: err}
+	}
+	return nil
+}
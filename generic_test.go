@@ -0,0 +1,92 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// openTestDB opens an empty sqlite database in the test's temp dir, with the sqlite dialect.
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	saved := sqlDialect
+	t.Cleanup(func() { sqlDialect = saved })
+	sqlDialect = sqliteDialect
+	db, err := sqlx.Open(sqlDialect.driver, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestGenericStringPKPagination(t *testing.T) {
+	withCleaningConfig(t)
+	db := openTestDB(t)
+	db.MustExec(`CREATE TABLE documents (doc_id TEXT PRIMARY KEY, file_url TEXT)`)
+	// Inserted out of order; string order differs from numeric order ("10" < "9").
+	seed := map[string]string{
+		"9":    "https://h/9.pdf?tag=a",
+		"10":   "https://h/10.pdf?tag=b&v=1",
+		"a-1":  "https://h/a1.pdf",
+		"b-2":  "https://h/b2.pdf?tagging=c",
+		"b-10": "",
+		"c":    "https://h/c.pdf?tag=d",
+		"d":    "https://h/d.pdf?tag=e",
+	}
+	for pk, u := range seed {
+		db.MustExec(`INSERT INTO documents (doc_id, file_url) VALUES (?, ?)`, pk, u)
+	}
+
+	tbl := genericTable{Table: "documents", PKColumn: "doc_id", URLColumn: "file_url", StringPK: true}
+	var pks []string
+	for last := tbl.firstPK(); ; {
+		rows, err := fetchGenericBatch(t.Context(), db, tbl, last, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, r := range rows {
+			pks = append(pks, r.PK.String())
+		}
+		last = rows[len(rows)-1].PK
+	}
+	want := []string{"10", "9", "a-1", "b-2", "c", "d"}
+	if strings.Join(pks, ",") != strings.Join(want, ",") {
+		t.Fatalf("paged pks = %v, want %v", pks, want)
+	}
+
+	if err := migrateGenericRemoveTag(t.Context(), db, tbl, false, 2); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	rows, err := db.Queryx(`SELECT doc_id, file_url FROM documents`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pk, u string
+		if err := rows.Scan(&pk, &u); err != nil {
+			t.Fatal(err)
+		}
+		got[pk] = u
+	}
+	wantURLs := map[string]string{
+		"9":    "https://h/9.pdf",
+		"10":   "https://h/10.pdf?v=1",
+		"a-1":  "https://h/a1.pdf",
+		"b-2":  "https://h/b2.pdf",
+		"b-10": "",
+		"c":    "https://h/c.pdf",
+		"d":    "https://h/d.pdf",
+	}
+	if !maps.Equal(got, wantURLs) {
+		t.Errorf("documents after migration = %v, want %v", got, wantURLs)
+	}
+}
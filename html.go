@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ------------------------------
+// HTML columns (HTML_COLUMNS)
+// ------------------------------
+
+// htmlColumns are TEXT columns holding HTML whose links are cleaned, configured via
+// HTML_COLUMNS="partner.description,client.client_notes". Each one runs as its own migration,
+// named table.column, after the table migrations. The table must be a built-in table or an
+// EXTRA_TABLES entry (its pk column is taken from there).
+var htmlColumns []genericTable
+
+// htmlURLAttrs are the attributes whose value is a URL to clean.
+var htmlURLAttrs = map[string]bool{"href": true, "src": true}
+
+// parseHTMLColumns parses the HTML_COLUMNS spec. Must run after EXTRA_TABLES is parsed.
+func parseHTMLColumns(spec string) ([]genericTable, error) {
+	pkColumns := map[string]string{"bulk": "id", "partner": "partner_id", "client": "client_id"}
+	for _, t := range genericTables {
+		pkColumns[t.Table] = t.PKColumn
+	}
+
+	var cols []genericTable
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		table, col, ok := strings.Cut(entry, ".")
+		if !ok || !isSQLIdentifier(table) || !isSQLIdentifier(col) {
+			return nil, &ConfigError{Key: "HTML_COLUMNS", Err: fmt.Errorf("entry %q must be table.column", entry)}
+		}
+		pkCol, known := pkColumns[table]
+		if !known {
+			return nil, &ConfigError{Key: "HTML_COLUMNS", Err: fmt.Errorf("entry %q: unknown table %q", entry, table)}
+		}
+		stringPK := false
+		for _, t := range genericTables {
+			if t.Table == table {
+				stringPK = t.StringPK
+			}
+		}
+		cols = append(cols, genericTable{Table: table, PKColumn: pkCol, URLColumn: col, StringPK: stringPK, HTML: true})
+	}
+	return cols, nil
+}
+
+// htmlLinks returns the href/src values of every tag in doc.
+func htmlLinks(doc string) []string {
+	var links []string
+	z := html.NewTokenizer(strings.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		for _, a := range z.Token().Attr {
+			if a.Namespace == "" && htmlURLAttrs[a.Key] {
+				links = append(links, a.Val)
+			}
+		}
+	}
+}
+
+// htmlRemovedTagPairs returns the tag param pairs dropped from the links of oldDoc by cleanHTML.
+// cleanHTML only rewrites attribute values, so the links of both documents pair up in order.
+func htmlRemovedTagPairs(oldDoc, newDoc string) []string {
+	oldLinks, newLinks := htmlLinks(oldDoc), htmlLinks(newDoc)
+	if len(oldLinks) != len(newLinks) {
+		return nil
+	}
+	var removed []string
+	for i := range oldLinks {
+		if oldLinks[i] != newLinks[i] {
+			removed = append(removed, removedTagPairs(oldLinks[i], newLinks[i])...)
+		}
+	}
+	return removed
+}
+
+// cleanHTML cleans the href/src URLs of every tag in doc with cleanURL. Only tags with a changed
+// URL are re-serialized; all other markup and text is copied byte for byte, so content that is
+// not HTML comes back unchanged.
+func cleanHTML(doc string) (string, bool) {
+	if !strings.Contains(doc, "<") {
+		return doc, false
+	}
+	var b strings.Builder
+	changed := false
+	z := html.NewTokenizer(strings.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return doc, false
+			}
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			b.Write(z.Raw())
+			continue
+		}
+
+		// Copied first: Token lower-cases the tag in the tokenizer's buffer.
+		raw := append([]byte(nil), z.Raw()...)
+		tok := z.Token()
+		tagChanged := false
+		for i, a := range tok.Attr {
+			if a.Namespace != "" || !htmlURLAttrs[a.Key] {
+				continue
+			}
+			if cleaned, ok := cleanURL(a.Val); ok {
+				tok.Attr[i].Val = cleaned
+				tagChanged = true
+			}
+		}
+		if !tagChanged {
+			b.Write(raw)
+			continue
+		}
+		changed = true
+		b.WriteString(tok.String())
+	}
+	if !changed {
+		return doc, false
+	}
+	return b.String(), true
+}
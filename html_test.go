@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanHTML(t *testing.T) {
+	withCleaningConfig(t)
+	tests := []struct {
+		name, in, want string
+		changed        bool
+	}{
+		{"not HTML", "https://h/a.pdf?tag=1", "https://h/a.pdf?tag=1", false},
+		{"single link", `<p>See <a href="https://h/a.pdf?tag=1">file</a>.</p>`,
+			`<p>See <a href="https://h/a.pdf">file</a>.</p>`, true},
+		{"multiple links, one clean", `<a href="https://h/a?tag=1">a</a> <a href="https://h/b?v=2">b</a> <img src="https://h/c.png?v=3&tagging=4">`,
+			`<a href="https://h/a">a</a> <a href="https://h/b?v=2">b</a> <img src="https://h/c.png?v=3">`, true},
+		{"nested tags", `<DIV class=x><a href="https://h/a?tag=1"><IMG SRC='https://h/i.png?tag=2' alt=""></a></DIV>`,
+			`<DIV class=x><a href="https://h/a"><img src="https://h/i.png" alt=""></a></DIV>`, true},
+		{"unchanged tags keep their raw bytes", `<A  HREF='https://h/a?v=1'  data-x=1>x</A><br/><a href=https://h/b?tag=1>y</a>`,
+			`<A  HREF='https://h/a?v=1'  data-x=1>x</A><br/><a href="https://h/b">y</a>`, true},
+		{"entities in text and attrs kept", `<p title="a &amp; b">&lt;x&gt; <a href="https://h/a?x=1&amp;tag=2">a</a></p>`,
+			`<p title="a &amp; b">&lt;x&gt; <a href="https://h/a?x=1">a</a></p>`, true},
+		{"other attributes not cleaned", `<a title="https://h/a?tag=1" href="https://h/b">b</a>`,
+			`<a title="https://h/a?tag=1" href="https://h/b">b</a>`, false},
+		{"comments and scripts kept", `<!-- <a href="https://h/a?tag=1"> --><script>var u = "https://h/a?tag=1";</script>`,
+			`<!-- <a href="https://h/a?tag=1"> --><script>var u = "https://h/a?tag=1";</script>`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, changed := cleanHTML(tc.in)
+			if got != tc.want || changed != tc.changed {
+				t.Errorf("cleanHTML(%s)\n got %s, %v\nwant %s, %v", tc.in, got, changed, tc.want, tc.changed)
+			}
+		})
+	}
+}
+
+func TestHTMLRemovedTagPairs(t *testing.T) {
+	withCleaningConfig(t)
+	doc := `<a href="https://h/a?tag=1&v=2">a</a><a href="https://h/b">b</a><img src="https://h/c?tagging=3&tag=4">`
+	cleaned, _ := cleanHTML(doc)
+	got := htmlRemovedTagPairs(doc, cleaned)
+	want := []string{"tag=1", "tagging=3", "tag=4"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("htmlRemovedTagPairs = %v, want %v", got, want)
+	}
+}
+
+func TestHTMLColumnSkipsIneligibleRows(t *testing.T) {
+	withCleaningConfig(t)
+	if err := loadFilterColumns(); err != nil {
+		t.Fatal(err)
+	}
+	db := openTestDB(t)
+	db.MustExec(`CREATE TABLE partner (partner_id INTEGER PRIMARY KEY, partner_is_banned INTEGER, partner_contract_end TEXT, description TEXT)`)
+	db.MustExec(`INSERT INTO partner VALUES
+		(1, 0, '2999-01-01 00:00:00', '<a href="https://h/a?tag=1">a</a>'),
+		(2, 1, '2999-01-01 00:00:00', '<a href="https://h/b?tag=1">b</a>'),
+		(3, 0, '2000-01-01 00:00:00', '<a href="https://h/c?tag=1">c</a>')`)
+
+	tbl := genericTable{Table: "partner", PKColumn: "partner_id", URLColumn: "description", HTML: true}
+	rows, err := fetchGenericBatch(t.Context(), db, tbl, tbl.firstPK(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].PK.String() != "1" {
+		t.Fatalf("fetched %+v, want only the eligible partner_id=1", rows)
+	}
+}
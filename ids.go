@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Explicit pk lists (IDS_FILE / IDS_TABLE)
+// ------------------------------
+
+// idsLists maps each table fetched from an explicit pk list to its pks, sorted and unique. When
+// it is set, only those tables' migrations run, and they process exactly the listed pks instead
+// of paginating over the eligibility filters, in chunks of BATCH_SIZE with WHERE pk IN (...).
+// The lists come from IDS_FILE (one table, one pk per line, "-" = stdin) or MODE=reclean.
+var idsLists map[string][]int64
+
+// loadIDs reads pks from path ("-" for stdin), sorted ascending and de-duplicated.
+func loadIDs(path string) ([]int64, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	seen := make(map[int64]bool)
+	var ids []int64
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pk %q", lineNum, line)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// runsTable reports whether the migration for table should run at all.
+func runsTable(table string) bool {
+	if selectedTables != nil && !selectedTables[table] {
+		return false
+	}
+	return idsLists == nil || usesIDList(table)
+}
+
+// usesIDList reports whether table is fetched from an explicit pk list.
+func usesIDList(table string) bool {
+	_, ok := idsLists[table]
+	return ok
+}
+
+// nextIDChunk returns up to limit listed pks of table greater than lastID.
+func nextIDChunk(table string, lastID int64, limit int) []int64 {
+	ids := idsLists[table]
+	start := sort.Search(len(ids), func(i int) bool { return ids[i] > lastID })
+	end := start + limit
+	if end > len(ids) {
+		end = len(ids)
+	}
+	return ids[start:end]
+}
+
+// selectByIDs fetches the listed pks after lastID into dest (a pointer to a slice), chunk by
+// chunk, skipping chunks whose pks no longer exist. selectCols is the SELECT list; the result is
+// empty once the list is exhausted.
+func selectByIDs(ctx context.Context, db *sqlx.DB, dest interface{}, table, pkCol, selectCols string, lastID int64, limit int, rowCount func() int) error {
+	for {
+		ids := nextIDChunk(table, lastID, limit)
+		if len(ids) == 0 {
+			return nil
+		}
+		query, args, err := idsBatchQuery(table, pkCol, selectCols, ids)
+		if err != nil {
+			return err
+		}
+		if err := db.SelectContext(ctx, dest, query, args...); err != nil {
+			return &DBError{Op: "select " + table, Err: err}
+		}
+		if rowCount() > 0 {
+			return nil
+		}
+		lastID = ids[len(ids)-1]
+	}
+}
+
+// idsBatchQuery builds the SELECT of the listed pks ids.
+func idsBatchQuery(table, pkCol, selectCols string, ids []int64) (string, []interface{}, error) {
+	return sqlx.In(
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (?) ORDER BY %s ASC", selectCols, table, pkCol, pkCol),
+		ids,
+	)
+}
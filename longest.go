@@ -0,0 +1,77 @@
+package main
+
+import (
+	"container/heap"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ------------------------------
+// Longest URLs (LONGEST_URLS)
+// ------------------------------
+
+// longestURLsN (LONGEST_URLS) is how many of the longest URLs seen per table are printed in the
+// summary; 0 disables tracking. Very long values usually mean encoding bugs or embedded data.
+var longestURLsN int
+
+// longestURLShown is how many characters of each long URL are printed.
+const longestURLShown = 200
+
+type longURL struct {
+	pk  pkValue
+	url string
+}
+
+// longURLHeap is a min-heap by length, so the shortest of the current top N is evicted first.
+type longURLHeap []longURL
+
+func (h longURLHeap) Len() int           { return len(h) }
+func (h longURLHeap) Less(i, j int) bool { return len(h[i].url) < len(h[j].url) }
+func (h longURLHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *longURLHeap) Push(x any)        { *h = append(*h, x.(longURL)) }
+func (h *longURLHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// trackLongest offers the URLs of row pk to the bounded top-N heap.
+func (s *migrationStats) trackLongest(pk pkValue, urls ...string) {
+	if longestURLsN <= 0 {
+		return
+	}
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if s.longest.Len() < longestURLsN {
+			heap.Push(&s.longest, longURL{pk: pk, url: raw})
+			continue
+		}
+		if len(raw) > len(s.longest[0].url) {
+			s.longest[0] = longURL{pk: pk, url: raw}
+			heap.Fix(&s.longest, 0)
+		}
+	}
+}
+
+// logLongest prints the tracked URLs, longest first, truncated to longestURLShown characters.
+func (s *migrationStats) logLongest(label, pkCol string) {
+	if longestURLsN <= 0 || s.longest.Len() == 0 {
+		return
+	}
+	top := append([]longURL(nil), s.longest...)
+	sort.Slice(top, func(i, j int) bool { return len(top[i].url) > len(top[j].url) })
+
+	log.Printf("[%s][SUMMARY] longest URLs (top %d)", label, len(top))
+	for _, u := range top {
+		shown := u.url
+		if len(shown) > longestURLShown {
+			shown = shown[:longestURLShown] + "..."
+		}
+		log.Printf("[%s][SUMMARY]   %s=%s len=%d url=%s", label, pkCol, u.pk, len(u.url), shown)
+	}
+}
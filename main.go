@@ -5,16 +5,24 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ------------------------------
@@ -36,6 +44,23 @@ type ClientRow struct {
 	ClientContractAttachment sql.NullString `db:"client_contract_attachment_url"`
 	ClientTaxAttachment      sql.NullString `db:"client_tax_attachment"`
 	ClientPksAttachment      sql.NullString `db:"client_pks_attachment"`
+	// Current values of the CLIENT_COLUMN_MAP write columns, selected as written_<read column>
+	// (see clientSelectColumns). Only mapped columns are filled.
+	ContractWritten sql.NullString `db:"written_client_contract_attachment_url"`
+	TaxWritten      sql.NullString `db:"written_client_tax_attachment"`
+	PksWritten      sql.NullString `db:"written_client_pks_attachment"`
+}
+
+// writtenValue returns the current value of the CLIENT_COLUMN_MAP write column of read column col.
+func (r ClientRow) writtenValue(col string) sql.NullString {
+	switch col {
+	case "client_contract_attachment_url":
+		return r.ContractWritten
+	case "client_tax_attachment":
+		return r.TaxWritten
+	default:
+		return r.PksWritten
+	}
 }
 
 // ------------------------------
@@ -44,22 +69,80 @@ type ClientRow struct {
 
 var (
 	hydraSignPrefix string
+	// hydraSignPrefixes are all prefixes the client migration may touch: the lines of
+	// HYDRA_PREFIXES_FILE, or just hydraSignPrefix when no file is given.
+	hydraSignPrefixes []string
 )
 
 var bulkS3Prefix string
 
+// bulkNoFilenameClean (BULK_NO_FILENAME=clean) writes a tag-stripped bulk archive URL that has no
+// filename to normalize (e.g. https://host/?tag=x) as is. By default such rows are skipped with
+// reason no-filename.
+var bulkNoFilenameClean bool
+
+// keepEmpty (KEEP_EMPTY, on by default) skips a bulk row whose cleaned archive_file would be just
+// BULK_S3_PREFIX without a filename, with reason bare-prefix. KEEP_EMPTY=0 writes such values.
+// NULL and blank archive_file values are kept unchanged either way.
+var keepEmpty = true
+
+// skipBarePrefix is the skip reason for a bulk row held back by KEEP_EMPTY.
+const skipBarePrefix = "bare-prefix"
+
+// URL_INCLUDE_REGEX / URL_EXCLUDE_REGEX: a URL is only cleaned if it matches include (when set)
+// and does not match exclude (when set).
+var (
+	urlIncludeRegex *regexp.Regexp
+	urlExcludeRegex *regexp.Regexp
+)
+
+// tagValueRegex (TAG_VALUE_REGEX) restricts tag removal to params whose decoded value matches,
+// e.g. UUID tracking ids, so human-readable tag values used as labels are kept.
+var tagValueRegex *regexp.Regexp
+
+// FORCE_HTTPS=1 upgrades http:// URLs to https:// during cleaning, but only for hosts listed in
+// FORCE_HTTPS_HOSTS (comma-separated) so third-party URLs are never touched.
+var (
+	forceHTTPS      bool
+	forceHTTPSHosts map[string]bool
+)
+
+// normalizeOnly (NORMALIZE_ONLY=1) disables tag removal: only the host/prefix rewrites run
+// (bulk archive normalization to BULK_S3_PREFIX and FORCE_HTTPS), and query params are kept
+// byte for byte, e.g. to move every bulk archive to a new bucket.
+var normalizeOnly bool
+
+// spaceEncoding (SPACE_ENCODING) is how spaces are written in a re-encoded query: "plus" (+),
+// "percent" (%20) or "" to follow the original query, so the diff stays minimal.
+var spaceEncoding string
+
+// pauseFile (PAUSE_FILE): while this file exists, migrations wait before fetching the next batch.
+var pauseFile string
+
+// partnerJSONPrefilter enables a MySQL JSON_SEARCH predicate in fetchPartnerBatch
+// (PARTNER_JSON_PREFILTER=1). It is switched off at startup if the server lacks JSON support.
+var partnerJSONPrefilter bool
+
+// runID identifies one invocation of the tool in generated artifacts.
+var runID string
+
 var (
 	errorLogFile    *os.File
 	errorLogEncoder *json.Encoder
+	errorLogMu      sync.Mutex
 )
 
+// ctxCheckEvery is how often (in rows) the per-row loops check for cancellation.
+const ctxCheckEvery = 50
+
 // ------------------------------
-// Init
+// Config
 // ------------------------------
 
-func init() {
+// loadConfig reads .env and the environment into the globals above. Failures are returned as *ConfigError.
+func loadConfig() error {
 	if err := loadDotEnvFile(".env"); err != nil && !os.IsNotExist(err) {
-		log.Fatalf("failed to load .env: %v", err)
+		return &ConfigError{Key: ".env", Err: err}
 	}
 
 	// Hydra sign prefix (for client attachments)
@@ -68,6 +151,17 @@ func init() {
 		// default for safety
 		hydraSignPrefix = "https://api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?"
 	}
+	hydraSignPrefixes = []string{hydraSignPrefix}
+	if path := strings.TrimSpace(os.Getenv("HYDRA_PREFIXES_FILE")); path != "" {
+		prefixes, err := loadLines(path)
+		if err != nil {
+			return &ConfigError{Key: "HYDRA_PREFIXES_FILE", Err: err}
+		}
+		if len(prefixes) == 0 {
+			return &ConfigError{Key: "HYDRA_PREFIXES_FILE", Err: fmt.Errorf("%s contains no prefixes", path)}
+		}
+		hydraSignPrefixes = prefixes
+	}
 
 	// Bulk S3 prefix (for bulk.archive_file)
 	// Example:
@@ -78,6 +172,250 @@ func init() {
 		// safe default for local/dev usage; override via env in real envs
 		bulkS3Prefix = "https://dev-genesis.s3.ap-southeast-1.amazonaws.com/"
 	}
+	switch v := strings.TrimSpace(os.Getenv("BULK_NO_FILENAME")); v {
+	case "", "skip":
+		bulkNoFilenameClean = false
+	case "clean":
+		bulkNoFilenameClean = true
+	default:
+		return &ConfigError{Key: "BULK_NO_FILENAME", Err: fmt.Errorf("must be skip or clean, got %q", v)}
+	}
+	keepEmpty = os.Getenv("KEEP_EMPTY") != "0"
+
+	switch runMode = strings.TrimSpace(os.Getenv("MODE")); runMode {
+	case modeMigrate, modePrintQueries, modePrefixAudit, modeReconcile, modeReclean, modeReviewQuarantine:
+	default:
+		return &ConfigError{Key: "MODE", Err: fmt.Errorf("unknown mode %q", runMode)}
+	}
+
+	storagePrefixes = nil
+	for _, p := range strings.Split(os.Getenv("STORAGE_PREFIXES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			storagePrefixes = append(storagePrefixes, p)
+		}
+	}
+	if spec := os.Getenv("TAG_PARAMS"); strings.TrimSpace(spec) != "" {
+		params, err := parseTagParams(spec)
+		if err != nil {
+			return err
+		}
+		tagParams = params
+	}
+	quarantinePath = strings.TrimSpace(os.Getenv("QUARANTINE_OUT"))
+	expectedHosts = make(map[string]bool)
+	for _, h := range strings.Split(os.Getenv("QUARANTINE_HOSTS"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			expectedHosts[h] = true
+		}
+	}
+	partnerMetaMaxBytes = loadNonNegativeIntFromEnv("PARTNER_META_MAX_BYTES", 0)
+	tagParamsQuery = strings.TrimSpace(os.Getenv("TAG_PARAMS_QUERY"))
+	if tagParamsQuery != "" && !strings.EqualFold(strings.Fields(tagParamsQuery)[0], "SELECT") {
+		return &ConfigError{Key: "TAG_PARAMS_QUERY", Err: errors.New("must be a SELECT statement")}
+	}
+
+	partnerJSONPrefilter = os.Getenv("PARTNER_JSON_PREFILTER") == "1"
+	partnerStream = os.Getenv("PARTNER_STREAM") == "1"
+	parallelTables = os.Getenv("PARALLEL_TABLES") == "1"
+	debugOnError = os.Getenv("DEBUG_ON_ERROR") == "1"
+	dryRunVerify = os.Getenv("DRYRUN_VERIFY") == "1"
+	reportRemovedParams = os.Getenv("REPORT_REMOVED_PARAMS") == "1"
+	batchTxEnabled = os.Getenv("BATCH_TX") == "1"
+	maxTxRows = loadNonNegativeIntFromEnv("MAX_TX_ROWS", 0)
+	if maxTxRows > 0 && !batchTxEnabled {
+		return &ConfigError{Key: "MAX_TX_ROWS", Err: errors.New("requires BATCH_TX=1")}
+	}
+	checkPrefilterTagParams()
+	shadowApply = os.Getenv("SHADOW_APPLY") == "1"
+	copyMode = os.Getenv("COPY_MODE") == "1"
+	if copyMode && shadowApply {
+		return &ConfigError{Key: "COPY_MODE", Err: errors.New("cannot be combined with SHADOW_APPLY=1")}
+	}
+	pauseFile = strings.TrimSpace(os.Getenv("PAUSE_FILE"))
+	partnerMetaVerify = os.Getenv("PARTNER_META_VERIFY") != "0"
+	dryRunNDJSON = os.Getenv("DRYRUN_NDJSON") == "1"
+	purgeListPath = strings.TrimSpace(os.Getenv("PURGE_LIST_OUT"))
+	progressTable = strings.TrimSpace(os.Getenv("PROGRESS_TABLE"))
+	if progressTable != "" && !isSQLIdentifier(progressTable) {
+		return &ConfigError{Key: "PROGRESS_TABLE", Err: fmt.Errorf("%q is not a valid table name", progressTable)}
+	}
+	maxWrites = loadNonNegativeIntFromEnv("MAX_WRITES", 0)
+	recentErrorsMax = loadNonNegativeIntFromEnv("RECENT_ERRORS", 20)
+	replLagQuery = strings.TrimSpace(os.Getenv("REPL_LAG_QUERY"))
+	changelogPath = strings.TrimSpace(os.Getenv("CHANGELOG_OUT"))
+	maxReplLag = loadNonNegativeIntFromEnv("MAX_REPL_LAG", 30)
+	sanityMaxCandidates = loadNonNegativeIntFromEnv("SANITY_MAX_CANDIDATES", 0)
+	sanityOverride = os.Getenv("SANITY_OVERRIDE") == "1"
+	longestURLsN = loadNonNegativeIntFromEnv("LONGEST_URLS", 0)
+	fetchRetryMax = loadNonNegativeIntFromEnv("FETCH_RETRY_MAX", 0)
+	if v := strings.TrimSpace(os.Getenv("BATCH_SLEEP")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return &ConfigError{Key: "BATCH_SLEEP", Err: fmt.Errorf("invalid duration %q", v)}
+		}
+		batchSleep = d
+	}
+	batchSleepJitter = os.Getenv("BATCH_SLEEP_JITTER") == "1"
+	postCheckParams = os.Getenv("POST_CHECK_PARAMS") == "1"
+	postCheckAbort = os.Getenv("POST_CHECK_ABORT") == "1"
+	canaryPercent = loadNonNegativeIntFromEnv("CANARY_PERCENT", 0)
+	if canaryPercent > 100 {
+		return &ConfigError{Key: "CANARY_PERCENT", Err: fmt.Errorf("must be between 0 and 100, got %d", canaryPercent)}
+	}
+
+	forceHTTPS = os.Getenv("FORCE_HTTPS") == "1"
+	forceHTTPSHosts = make(map[string]bool)
+	for _, h := range strings.Split(os.Getenv("FORCE_HTTPS_HOSTS"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			forceHTTPSHosts[h] = true
+		}
+	}
+	if forceHTTPS && len(forceHTTPSHosts) == 0 {
+		return &ConfigError{Key: "FORCE_HTTPS_HOSTS", Err: errors.New("required when FORCE_HTTPS=1")}
+	}
+
+	normalizeOnly = os.Getenv("NORMALIZE_ONLY") == "1"
+	if normalizeOnly && strings.TrimSpace(os.Getenv("TAG_VALUE_REGEX")) != "" {
+		return &ConfigError{Key: "TAG_VALUE_REGEX", Err: errors.New("cannot be combined with NORMALIZE_ONLY=1")}
+	}
+
+	switch spaceEncoding = strings.ToLower(strings.TrimSpace(os.Getenv("SPACE_ENCODING"))); spaceEncoding {
+	case "", "plus", "percent":
+	default:
+		return &ConfigError{Key: "SPACE_ENCODING", Err: fmt.Errorf("must be plus or percent, got %q", spaceEncoding)}
+	}
+
+	var err error
+	if err := loadFilterColumns(); err != nil {
+		return err
+	}
+	if modifiedSince, err = parseModifiedSince(os.Getenv("MODIFIED_SINCE")); err != nil {
+		return err
+	}
+	if markColumns, err = parseMarkColumns(os.Getenv("MARK_COLUMN")); err != nil {
+		return err
+	}
+	if clientWriteColumns, err = parseClientColumnMap(os.Getenv("CLIENT_COLUMN_MAP")); err != nil {
+		return err
+	}
+	if sqlDialect, err = parseDialect(os.Getenv("DB_DRIVER")); err != nil {
+		return err
+	}
+	if parallelTables && sqlDialect.isSQLite() {
+		// SQLite allows a single writer; concurrent migrations would fail with "database is locked".
+		return &ConfigError{Key: "PARALLEL_TABLES", Err: errors.New("not supported with DB_DRIVER=sqlite")}
+	}
+	shardCount = 1
+	if v := strings.TrimSpace(os.Getenv("SHARDS")); v != "" {
+		if shardCount, err = strconv.Atoi(v); err != nil || shardCount < 1 {
+			return &ConfigError{Key: "SHARDS", Err: fmt.Errorf("must be a positive integer, got %q", v)}
+		}
+	}
+	if shardCount > 1 && sqlDialect.isSQLite() {
+		// SQLite allows a single writer; concurrent shards would fail with "database is locked".
+		return &ConfigError{Key: "SHARDS", Err: errors.New("not supported with DB_DRIVER=sqlite")}
+	}
+	if genericTables, err = parseGenericTables(os.Getenv("EXTRA_TABLES")); err != nil {
+		return err
+	}
+	if err = parseSinks(os.Getenv("SINK")); err != nil {
+		return err
+	}
+	if len(eventSinks) > 0 && batchTxEnabled {
+		// Events would be sent before the transaction commits.
+		return &ConfigError{Key: "SINK", Err: errors.New("event sinks cannot be combined with BATCH_TX=1")}
+	}
+	if len(eventSinks) > 0 && shadowApply {
+		return &ConfigError{Key: "SINK", Err: errors.New("event sinks cannot be combined with SHADOW_APPLY=1")}
+	}
+	if len(eventSinks) > 0 && copyMode {
+		return &ConfigError{Key: "SINK", Err: errors.New("event sinks cannot be combined with COPY_MODE=1")}
+	}
+	if htmlColumns, err = parseHTMLColumns(os.Getenv("HTML_COLUMNS")); err != nil {
+		return err
+	}
+	if len(htmlColumns) > 0 && copyMode {
+		// A _cleaned copy holds the table's URL columns, not free-form HTML columns.
+		return &ConfigError{Key: "HTML_COLUMNS", Err: errors.New("cannot be combined with COPY_MODE=1")}
+	}
+	resignEndpoint = strings.TrimSpace(os.Getenv("RESIGN_URL"))
+	if resignColumns, err = parseResignColumns(os.Getenv("RESIGN_COLUMNS")); err != nil {
+		return err
+	}
+	if resignEndpoint == "" && len(resignColumns) > 0 {
+		return &ConfigError{Key: "RESIGN_COLUMNS", Err: errors.New("requires RESIGN_URL")}
+	}
+	if resignEndpoint != "" && len(resignColumns) == 0 {
+		return &ConfigError{Key: "RESIGN_URL", Err: errors.New("requires RESIGN_COLUMNS")}
+	}
+	if resignEndpoint != "" {
+		if u, perr := url.Parse(resignEndpoint); perr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ConfigError{Key: "RESIGN_URL", Err: fmt.Errorf("invalid endpoint %q", resignEndpoint)}
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("RESIGN_TIMEOUT")); v != "" {
+		d, perr := time.ParseDuration(v)
+		if perr != nil || d <= 0 {
+			return &ConfigError{Key: "RESIGN_TIMEOUT", Err: fmt.Errorf("invalid duration %q", v)}
+		}
+		resignClient.Timeout = d
+	}
+	if migrationOrder, err = parseMigrateOrder(os.Getenv("MIGRATE_ORDER")); err != nil {
+		return err
+	}
+	if selectedTables, err = parseTables(os.Getenv("TABLES")); err != nil {
+		return err
+	}
+	auditDisabled = make(map[string]bool)
+	for _, table := range allTables() {
+		key := "AUDIT_" + strings.ToUpper(table)
+		switch v := strings.TrimSpace(os.Getenv(key)); v {
+		case "", "1":
+		case "0":
+			auditDisabled[table] = true
+		default:
+			return &ConfigError{Key: key, Err: fmt.Errorf("must be 0 or 1, got %q", v)}
+		}
+	}
+	idsLists = nil
+	if path := strings.TrimSpace(os.Getenv("IDS_FILE")); path != "" {
+		if runMode == modeReclean {
+			return &ConfigError{Key: "IDS_FILE", Err: errors.New("cannot be combined with MODE=reclean")}
+		}
+		idsTable := strings.TrimSpace(os.Getenv("IDS_TABLE"))
+		known := idsTable == "bulk" || idsTable == "partner" || idsTable == "client"
+		for _, t := range genericTables {
+			known = known || idsTable == t.Table
+			if idsTable == t.Table && t.StringPK {
+				return &ConfigError{Key: "IDS_TABLE", Err: fmt.Errorf("%q has a string pk; IDS_FILE lists integer pks only", idsTable)}
+			}
+		}
+		if !known {
+			return &ConfigError{Key: "IDS_TABLE", Err: fmt.Errorf("%q is not a known table (required with IDS_FILE)", idsTable)}
+		}
+		ids, err := loadIDs(path)
+		if err != nil {
+			return &ConfigError{Key: "IDS_FILE", Err: err}
+		}
+		idsLists = map[string][]int64{idsTable: ids}
+		log.Printf("IDS_FILE: processing %d listed %s pks only", len(ids), idsTable)
+	}
+	if runMode == modeReclean {
+		if idsLists, err = loadRecleanIDs(os.Getenv("RECLEAN_REPORT"), os.Getenv("RECLEAN_RUN_ID")); err != nil {
+			return err
+		}
+	}
+	if urlIncludeRegex, err = compileEnvRegex("URL_INCLUDE_REGEX"); err != nil {
+		return err
+	}
+	if urlExcludeRegex, err = compileEnvRegex("URL_EXCLUDE_REGEX"); err != nil {
+		return err
+	}
+	if tagValueRegex, err = compileEnvRegex("TAG_VALUE_REGEX"); err != nil {
+		return err
+	}
+
+	runID = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
 
 	// Error log file (JSON lines). Optional; falls back to stdout-only if it fails.
 	errorLogPath := os.Getenv("ERROR_LOG_PATH")
@@ -91,6 +429,7 @@ func init() {
 		errorLogFile = f
 		errorLogEncoder = json.NewEncoder(f)
 	}
+	return nil
 }
 
 // ------------------------------
@@ -98,45 +437,231 @@ func init() {
 // ------------------------------
 
 func main() {
-	ctx := context.Background()
+	if err := applyFlags(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Printf("FATAL: %v", err)
+		os.Exit(exitConfig)
+	}
+
+	// SIGINT/SIGTERM cancel ctx; migrations stop at the next row check and print their summary.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx)
+	stop()
+	if err != nil {
+		log.Printf("FATAL: %v", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func run(ctx context.Context) error {
+	if err := loadConfig(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	abortRun = cancel
+	if runMode == modeReviewQuarantine {
+		// Reads only the QUARANTINE_OUT file; no database is needed.
+		return reviewQuarantine(ctx)
+	}
+	if errorLogFile != nil {
+		defer errorLogFile.Close()
+	}
+	defer func() {
+		if err := closeSinks(); err != nil {
+			log.Printf("[WARN] closing sinks: %v", err)
+		}
+	}()
 
-	dsn := os.Getenv("DB_DSN")
-	if dsn == "" {
-		log.Fatal("DB_DSN env is required")
+	dsns, dsnKey, err := loadDSNs()
+	if err != nil {
+		return err
 	}
 
 	dryRun := os.Getenv("DRY_RUN") == "1"
+	if shadowApply && dryRun {
+		// SHADOW_APPLY is itself a dry run against the real tables: changes go to the shadow copies.
+		log.Println("SHADOW_APPLY=1 overrides DRY_RUN=1: changes are written to *_shadow tables")
+		dryRun = false
+	}
 	batchSize := loadBatchSizeFromEnv("BATCH_SIZE", 200)
+	logResolvedConfig(dsnKey, dsns, dryRun, batchSize)
+	defer closeOutputs()
+
+	if runMode != modeMigrate && runMode != modeReclean {
+		// The other modes run against a single DSN (see loadDSNs).
+		return runDSN(ctx, dsns[0], dryRun, batchSize)
+	}
 
-	db, err := sqlx.Open("mysql", dsn)
+	defer logRecentErrors()
+	defer logRemovedParams()
+
+	shutdownTracing, err := setupTracing(ctx)
 	if err != nil {
-		log.Fatalf("open db: %v", err)
+		return err
+	}
+	defer shutdownTracing()
+	spanCtx, span := tracer.Start(ctx, "remove-tagging run", trace.WithAttributes(
+		attribute.String("run.id", runID),
+		attribute.Bool("dry_run", dryRun),
+		attribute.Int("batch_size", batchSize),
+	))
+
+	started := time.Now()
+	err = runDSNs(spanCtx, dsns, dryRun, batchSize)
+	endSpan(span, err)
+	writeChangelog(started, dryRun, batchSize, err)
+	writePurgeList(dryRun)
+	if err != nil {
+		return err
 	}
-	defer db.Close()
 
-	if errorLogFile != nil {
-		defer errorLogFile.Close()
+	log.Println("remove tagging migration finished successfully")
+	return nil
+}
+
+// runDSN prepares the database at dsn (schema checks, output files, shadow/cleaned tables) and
+// runs the selected migrations, or the MODE, against it.
+func runDSN(ctx context.Context, dsn string, dryRun bool, batchSize int) error {
+	db, err := sqlx.Open(sqlDialect.driver, dsn)
+	if err != nil {
+		return &DBError{Op: "open", Err: err}
 	}
+	defer db.Close()
 
 	if err := db.Ping(); err != nil {
-		log.Fatalf("ping db: %v", err)
+		return &DBError{Op: "ping", Err: err}
+	}
+
+	if err := validateMarkColumns(ctx, db); err != nil {
+		return err
+	}
+	if err := validateFilterColumns(ctx, db); err != nil {
+		return err
+	}
+	if err := detectModifiedColumns(ctx, db); err != nil {
+		return err
+	}
+	if err := validateClientColumnMap(ctx, db); err != nil {
+		return err
+	}
+	if err := adaptToSchema(ctx, db); err != nil {
+		return err
+	}
+	if err := loadColumnMaxLengths(ctx, db); err != nil {
+		return err
 	}
+	loadTagParamsFromDB(ctx, db)
 
-	log.Printf("starting REMOVE TAGGING migration (dryRun=%v, batchSize=%d)", dryRun, batchSize)
+	if partnerJSONPrefilter {
+		if err := checkJSONSearchSupport(ctx, db); err != nil {
+			log.Printf("[WARN] PARTNER_JSON_PREFILTER=1 but server has no JSON_SEARCH support, disabling prefilter: %v", err)
+			partnerJSONPrefilter = false
+		}
+	}
 
-	if err := migrateBulkRemoveTag(ctx, db, dryRun, batchSize); err != nil {
-		log.Fatalf("bulk migration failed: %v", err)
+	switch runMode {
+	case modePrintQueries:
+		return printQueries(batchSize)
+	case modePrefixAudit:
+		return prefixAudit(ctx, db, batchSize)
+	case modeReconcile:
+		return reconcile(ctx, db)
 	}
 
-	if err := migratePartnerRemoveTag(ctx, db, dryRun, batchSize); err != nil {
-		log.Fatalf("partner migration failed: %v", err)
+	if err := checkCandidateCounts(ctx, db, dryRun); err != nil {
+		return err
 	}
+	if err := openReplLagDB(ctx, db, os.Getenv("REPL_LAG_DSN")); err != nil {
+		return err
+	}
+	defer closeReplLagDB(db)
+	if !dryRun {
+		if err := ensureProgressTable(ctx, db); err != nil {
+			return err
+		}
+	}
+	if err := openOutputs(dryRun); err != nil {
+		return err
+	}
+	writeRollbackDSN()
 
-	if err := migrateClientRemoveTag(ctx, db, dryRun, batchSize); err != nil {
-		log.Fatalf("client migration failed: %v", err)
+	if shadowApply {
+		for _, table := range allTables() {
+			if err := ensureShadowTable(ctx, db, table); err != nil {
+				return fmt.Errorf("create shadow table for %s: %w", table, err)
+			}
+		}
+	}
+	if copyMode && !dryRun {
+		for _, table := range allTables() {
+			if !runsTable(table) {
+				continue
+			}
+			if err := ensureCleanedTable(ctx, db, table); err != nil {
+				return fmt.Errorf("create %s: %w", cleanedTable(table), err)
+			}
+		}
+		log.Println("COPY_MODE=1: cleaned rows are written to <table>_cleaned; source tables are not updated")
 	}
 
-	log.Println("remove tagging migration finished successfully")
+	log.Printf("starting REMOVE TAGGING migration (dryRun=%v, shadowApply=%v, batchSize=%d, tagParams=%s, sinkDB=%v, eventSinks=%d)",
+		dryRun, shadowApply, batchSize, strings.Join(tagParams, ","), sinkDB, len(eventSinks))
+	return runMigrations(ctx, db, dryRun, batchSize)
+}
+
+// openOutputs opens the rollback script, report and eligible-unchanged files on the first call;
+// with DB_DSNS the later DSNs append to the same files. closeOutputs closes them at the end of
+// the run.
+func openOutputs(dryRun bool) error {
+	// Rollback SQL script (real runs only): inverse UPDATEs restoring old values.
+	if path := os.Getenv("ROLLBACK_SQL_OUT"); path != "" && rollbackSQLFile == nil && !dryRun && !shadowApply && !copyMode && sinkDB {
+		if err := openRollbackSQL(path); err != nil {
+			return &ConfigError{Key: "ROLLBACK_SQL_OUT", Err: err}
+		}
+		log.Printf("writing rollback SQL to %s (run_id=%s)", path, runID)
+		for _, table := range allTables() {
+			if auditDisabled[table] {
+				log.Printf("AUDIT_%s=0: no rollback SQL for %s", strings.ToUpper(table), table)
+			}
+		}
+	}
+
+	// Report of change records (JSON lines), written in both dry-run and real runs.
+	if path := os.Getenv("REPORT_OUT"); path != "" && reportFile == nil {
+		if err := openReport(path); err != nil {
+			return &ConfigError{Key: "REPORT_OUT", Err: err}
+		}
+		log.Printf("writing change report to %s (run_id=%s)", path, runID)
+	}
+
+	// Rows the SQL prefilter selected but Go left unchanged, for investigation.
+	if path := os.Getenv("ELIGIBLE_UNCHANGED_REPORT"); path != "" && eligibleUnchangedFile == nil {
+		if err := openEligibleUnchangedReport(path); err != nil {
+			return &ConfigError{Key: "ELIGIBLE_UNCHANGED_REPORT", Err: err}
+		}
+		log.Printf("writing eligible-but-unchanged rows to %s", path)
+	}
+
+	// Anomalous rows, for manual review (MODE=review-quarantine).
+	if quarantinePath != "" && quarantineFile == nil {
+		if err := openQuarantine(quarantinePath); err != nil {
+			return &ConfigError{Key: "QUARANTINE_OUT", Err: err}
+		}
+		log.Printf("writing quarantined rows to %s", quarantinePath)
+	}
+	return nil
+}
+
+func closeOutputs() {
+	for _, f := range []*os.File{rollbackSQLFile, reportFile, eligibleUnchangedFile, quarantineFile} {
+		if f != nil {
+			f.Close()
+		}
+	}
 }
 
 // ------------------------------
@@ -147,15 +672,34 @@ func migrateBulkRemoveTag(ctx context.Context, db *sqlx.DB, dryRun bool, batchSi
 	log.Println("== BULK: start remove tagging in archive_file ==")
 
 	var (
-		lastID       int64
+		lastID       = shardStart(ctx)
 		batchNum     int
 		totalRows    int
 		totalUpdated int
 		totalSkipped int
+		stoppedAt    int64
 	)
-
+	stats := newMigrationStats()
+	// Rows already started finish their write even if ctx is cancelled; the loop stops at the next check.
+	rowCtx := context.WithoutCancel(ctx)
+	btx := newBatchTx(db, "BULK")
+	var bspan batchSpan
+	defer bspan.abort()
+
+batches:
 	for {
-		rows, err := fetchBulkBatch(ctx, db, lastID, batchSize)
+		if ctx.Err() != nil {
+			break
+		}
+		waitWhilePaused(ctx, "BULK")
+		waitForReplication(ctx, "BULK")
+		if ctx.Err() != nil {
+			break
+		}
+
+		rows, err := fetchWithRetry(ctx, "BULK", func() ([]BulkRow, error) {
+			return fetchBulkBatch(ctx, db, lastID, batchSize)
+		})
 		if err != nil {
 			logErrorJSON("bulk_fetch_batch", map[string]interface{}{
 				"last_id":    lastID,
@@ -171,59 +715,138 @@ func migrateBulkRemoveTag(ctx context.Context, db *sqlx.DB, dryRun bool, batchSi
 		batchNum++
 		log.Printf("[BULK] batch #%d, size=%d, id range %d..%d",
 			batchNum, len(rows), rows[0].ID, rows[len(rows)-1].ID)
+		updatedBefore := totalUpdated
+		bspan.start(ctx, "bulk", batchNum, rows[0].ID, rows[len(rows)-1].ID, len(rows))
+
+		if shadowApply {
+			ids := make([]int64, 0, len(rows))
+			for _, r := range rows {
+				ids = append(ids, r.ID)
+			}
+			if err := copyToShadow(ctx, db, "bulk", "id", ids); err != nil {
+				return fmt.Errorf("copy bulk batch to shadow: %w", err)
+			}
+		}
 
-		for _, r := range rows {
+		if err := btx.begin(rowCtx); err != nil {
+			return fmt.Errorf("begin bulk batch: %w", err)
+		}
+		for i, r := range rows {
+			if i%ctxCheckEvery == 0 && ctx.Err() != nil {
+				log.Printf("[BULK] interrupted mid-batch, last processed id=%d", lastID)
+				break batches
+			}
 			totalRows++
 			lastID = r.ID
 
-			updated, skipped, err := processBulkRowRemoveTag(ctx, db, r, dryRun)
+			if !stats.inCanary(intPK(r.ID)) {
+				totalSkipped++
+				continue
+			}
+
+			updated, skipped, err := processBulkRowRemoveTag(btx.rowContext(rowCtx), btx.exec(), r, stats, dryRun)
+			if errors.Is(err, errMaxWritesReached) {
+				totalRows--
+				log.Printf("[BULK] MAX_WRITES=%d reached, stopping at id=%d (not processed)", maxWrites, r.ID)
+				stoppedAt = r.ID
+				break batches
+			}
 			if err != nil {
 				log.Printf("[BULK][ERROR] id=%d: %v", r.ID, err)
+				bspan.recordError(err)
 				logErrorJSON("bulk_process_row", map[string]interface{}{
 					"id":      r.ID,
 					"dry_run": dryRun,
 				}, err)
+				logRowContext("BULK", map[string]interface{}{
+					"id":           r.ID,
+					"archive_file": nullableValue(r.ArchiveFile.Valid, r.ArchiveFile.String),
+				}, err)
 				continue
 			}
 			if updated {
 				totalUpdated++
+				if err := btx.rowUpdated(rowCtx, intPK(r.ID)); err != nil {
+					return err
+				}
 			}
 			if skipped {
 				totalSkipped++
 			}
 		}
+		if err := btx.commit(); err != nil {
+			return err
+		}
+		recordProgress(rowCtx, db, "bulk", batchNum, rows[0].ID, rows[len(rows)-1].ID, totalUpdated-updatedBefore, dryRun)
+		bspan.end(totalUpdated - updatedBefore)
+		sleepBetweenBatches(ctx, "BULK")
+	}
+
+	if err := btx.commit(); err != nil {
+		return err
 	}
 
 	log.Printf("[BULK][SUMMARY] totalRows=%d totalUpdated=%d totalSkipped=%d", totalRows, totalUpdated, totalSkipped)
+	log.Printf("[BULK][SUMMARY] urlsCleaned=%d rowsUpdated=%d (dryRun=%v)", stats.urlsCleaned, totalUpdated, dryRun)
+	recordSummary("bulk", totalRows, totalUpdated, totalSkipped, stats.urlsCleaned)
+	stats.logSummary(ctx, "BULK", "id")
+
+	if stoppedAt != 0 {
+		return fmt.Errorf("bulk migration stopped at id=%d: %w", stoppedAt, errMaxWritesReached)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("bulk migration interrupted after id=%d: %w", lastID, context.Cause(ctx))
+	}
 	return nil
 }
 
 func fetchBulkBatch(ctx context.Context, db *sqlx.DB, lastID int64, limit int) ([]BulkRow, error) {
+	if usesIDList("bulk") {
+		var rows []BulkRow
+		err := selectByIDs(ctx, db, &rows, "bulk", "id", "id, archive_file", lastID, limit, func() int { return len(rows) })
+		return rows, err
+	}
+
+	query, args := bulkBatchQuery(ctx, lastID, limit)
+	var rows []BulkRow
+	if err := db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, &DBError{Op: "select bulk", Err: err}
+	}
+	return clipToShard(ctx, rows, func(r BulkRow) int64 { return r.ID }), nil
+}
+
+// bulkBatchQuery builds the bulk candidate SELECT for the batch after lastID, within the shard of ctx.
+func bulkBatchQuery(ctx context.Context, lastID int64, limit int) (string, []interface{}) {
+	shardSQL, shardArgs := shardEndSQL(ctx, "id")
+	modifiedSQL, modifiedArgs := modifiedSinceSQL("bulk")
 	query := `
 SELECT
     id,
     archive_file
 FROM bulk
 WHERE
-    id > ?
+    id > ?` + shardSQL + `
     AND archive_type = 'custom_client_rate'
-    AND created_at >= DATE_SUB(NOW(), INTERVAL 1 MONTH)
+    AND created_at >= ` + sqlDialect.monthAgo() + `
     AND archive_file IS NOT NULL
-    AND archive_file != ''
+    AND archive_file != ''` + markFilterSQL("bulk") + modifiedSQL + `
 ORDER BY id ASC
 LIMIT ?
 `
-	var rows []BulkRow
-	if err := db.SelectContext(ctx, &rows, query, lastID, limit); err != nil {
-		return nil, err
-	}
-	return rows, nil
+	args := append(append([]interface{}{lastID}, shardArgs...), modifiedArgs...)
+	return query, append(args, limit)
 }
 
+// processBulkRowRemoveTag follows the KEEP_EMPTY policy for archive_file: a NULL,
+// blank or whitespace-only value is always kept unchanged and never normalized,
+// because normalizing an empty filename would write the bare BULK_S3_PREFIX
+// (prefix plus trailing slash) into the row. With KEEP_EMPTY on, a non-blank value
+// that would be cleaned down to the bare prefix is skipped as well.
 func processBulkRowRemoveTag(
 	ctx context.Context,
-	db *sqlx.DB,
+	db sqlx.ExtContext,
 	row BulkRow,
+	stats *migrationStats,
 	dryRun bool,
 ) (updated bool, skipped bool, err error) {
 	if !row.ArchiveFile.Valid {
@@ -233,61 +856,145 @@ func processBulkRowRemoveTag(
 	if raw == "" {
 		return false, true, nil
 	}
-	newURL, changed := removeTagParamsFromURL(raw)
-	if !changed {
+	stats.addRowHosts(raw)
+	stats.trackLongest(intPK(row.ID), raw)
+
+	if reason := urlFilterSkipReason(raw); reason != "" {
+		log.Printf("[BULK][SKIP] id=%d reason=%s", row.ID, reason)
+		stats.skip(reason)
+		return false, true, nil
+	}
+	if err := checkParseableURL(raw); err != nil {
+		log.Printf("[BULK][SKIP] id=%d reason=%s: %v", row.ID, skipUnparseableURL, err)
+		stats.skip(skipUnparseableURL)
+		quarantineRow("bulk", "id", intPK(row.ID), dryRun, skipUnparseableURL, err.Error(), map[string]string{"archive_file": row.ArchiveFile.String})
+		return false, true, nil
+	}
+	if err := checkExpectedHost(raw); err != nil {
+		log.Printf("[BULK][SKIP] id=%d reason=%s: %v", row.ID, skipUnexpectedHost, err)
+		stats.skip(skipUnexpectedHost)
+		quarantineRow("bulk", "id", intPK(row.ID), dryRun, skipUnexpectedHost, err.Error(), map[string]string{"archive_file": row.ArchiveFile.String})
+		return false, true, nil
+	}
+
+	newURL, _ := cleanURL(raw)
+	// NORMALIZE_ONLY normalizes every archive; otherwise only those that lost a tag.
+	if !normalizeOnly && stats.alreadyClean(raw, newURL) {
 		return false, true, nil
 	}
 
 	// Normalize to use env-based S3 prefix for bulk files
-	newURL = normalizeBulkArchiveURL(newURL)
+	normalized, ok := normalizeBulkArchiveURL(newURL)
+	if !ok && !bulkNoFilenameClean {
+		log.Printf("[BULK][SKIP] id=%d reason=no-filename archive_file=%s", row.ID, raw)
+		stats.skip("no-filename")
+		return false, true, nil
+	}
+	newURL = normalized
+
+	// KEEP_EMPTY: never write a value that is just the prefix without a filename.
+	if keepEmpty && isBareBulkPrefix(newURL) {
+		log.Printf("[BULK][SKIP] id=%d reason=%s archive_file would become %q", row.ID, skipBarePrefix, newURL)
+		stats.skip(skipBarePrefix)
+		return false, true, nil
+	}
+	if stats.alreadyClean(raw, newURL) {
+		return false, true, nil
+	}
+
+	if resigns("bulk", "archive_file") {
+		signed, err := resignURL(ctx, newURL)
+		if err != nil {
+			log.Printf("[BULK][SKIP] id=%d reason=%s err=%v", row.ID, skipResignFailed, err)
+			stats.skip(skipResignFailed)
+			return false, true, nil
+		}
+		newURL = signed
+	}
+
+	rec := newChangeRecord("bulk", "id", intPK(row.ID), dryRun)
+	rec.addColumn("archive_file", row.ArchiveFile.String, newURL, removedTagPairs(raw, newURL)...)
+	if wouldTruncate("BULK", rec, stats) {
+		return false, true, nil
+	}
 
 	if dryRun {
-		log.Printf("[BULK][DRY-RUN] id=%d archive_file\nold=%s\nnew=%s", row.ID, raw, newURL)
+		stats.urlsCleaned++
+		stats.addChangedHosts(rec)
+		recordChange(rec)
+		if err := stats.verifyDryRunMatch(ctx, db, "BULK", rec); err != nil {
+			return false, false, err
+		}
+		if !emitDryRunNDJSON(rec) {
+			log.Printf("[BULK][DRY-RUN] id=%d archive_file\nold=%s\nnew=%s", row.ID, raw, newURL)
+		}
 		return false, false, nil
 	}
 
-	if err := updateBulkArchiveFile(ctx, db, row.ID, newURL); err != nil {
-		return false, false, fmt.Errorf("update DB: %w", err)
+	if err := applyChange(ctx, rec, func() error { return updateBulkArchiveFile(ctx, db, row.ID, newURL) }); err != nil {
+		return false, false, withNewValue(fmt.Errorf("apply change: %w", err), newURL)
 	}
 
+	stats.urlsCleaned++
+	stats.addChangedHosts(rec)
 	log.Printf("[BULK][OK] id=%d updated archive_file\nold=%s\nnew=%s", row.ID, raw, newURL)
 	return true, false, nil
 }
 
-func updateBulkArchiveFile(ctx context.Context, db *sqlx.DB, id int64, newURL string) error {
-	query := `
-UPDATE bulk
-SET archive_file = ?
+func updateBulkArchiveFile(ctx context.Context, db sqlx.ExecerContext, id int64, newURL string) error {
+	query := fmt.Sprintf(`
+UPDATE %s
+SET archive_file = ?%s
 WHERE id = ?
-`
+`, targetTable("bulk"), markSetSQL("bulk"))
+	if err := reserveWrite(); err != nil {
+		return err
+	}
+	if copyMode {
+		return writeCleanedRow(ctx, db, "bulk", id, map[string]string{"archive_file": newURL})
+	}
 	_, err := db.ExecContext(ctx, query, newURL, id)
-	return err
+	if err != nil {
+		return &DBError{Op: "update bulk", Err: err}
+	}
+	return nil
 }
 
 // normalizeBulkArchiveURL rebuilds the bulk archive URL using the BULK_S3_PREFIX env,
 // keeping only the filename part. If parsing fails, it returns the input as-is.
-func normalizeBulkArchiveURL(rawURL string) string {
+//
+// The filename is the last non-empty path segment, so https://host/dir/file.xlsx/ keeps
+// file.xlsx. A URL without one (https://host, https://host/) comes back unchanged with ok false.
+// With NORMALIZE_ONLY the query and fragment are kept as they are instead of dropped.
+func normalizeBulkArchiveURL(rawURL string) (normalized string, ok bool) {
 	if bulkS3Prefix == "" || rawURL == "" {
-		return rawURL
+		return rawURL, true
 	}
 
-	u, err := url.Parse(rawURL)
+	u, err := parseURL(rawURL)
 	if err != nil {
-		return rawURL
+		return rawURL, true
 	}
 
 	// Take only the last segment (file name), e.g. bulk_upload_client_rate_1754324774.xlsx
 	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
-	if len(parts) == 0 {
-		return rawURL
-	}
 	filename := parts[len(parts)-1]
 	if filename == "" {
-		return rawURL
+		return rawURL, false
 	}
 
 	prefix := strings.TrimRight(bulkS3Prefix, "/")
-	return prefix + "/" + filename
+	var suffix string
+	if i := strings.IndexAny(rawURL, "?#"); normalizeOnly && i >= 0 {
+		suffix = rawURL[i:]
+	}
+	return prefix + "/" + filename + suffix, true
+}
+
+// isBareBulkPrefix reports whether u is empty or just BULK_S3_PREFIX with no filename.
+func isBareBulkPrefix(u string) bool {
+	trimmed := strings.TrimRight(strings.TrimSpace(u), "/")
+	return trimmed == "" || trimmed == strings.TrimRight(bulkS3Prefix, "/")
 }
 
 // ------------------------------
@@ -298,15 +1005,34 @@ func migratePartnerRemoveTag(ctx context.Context, db *sqlx.DB, dryRun bool, batc
 	log.Println("== PARTNER: start remove tagging in meta.partner_pos_attach_files ==")
 
 	var (
-		lastID       int64
+		lastID       = shardStart(ctx)
 		batchNum     int
 		totalRows    int
 		totalUpdated int
 		totalSkipped int
+		stoppedAt    int64
 	)
-
+	stats := newMigrationStats()
+	// Rows already started finish their write even if ctx is cancelled; the loop stops at the next check.
+	rowCtx := context.WithoutCancel(ctx)
+	btx := newBatchTx(db, "PARTNER")
+	var bspan batchSpan
+	defer bspan.abort()
+
+batches:
 	for {
-		rows, err := fetchPartnerBatch(ctx, db, lastID, batchSize)
+		if ctx.Err() != nil {
+			break
+		}
+		waitWhilePaused(ctx, "PARTNER")
+		waitForReplication(ctx, "PARTNER")
+		if ctx.Err() != nil {
+			break
+		}
+
+		rows, err := fetchWithRetry(ctx, "PARTNER", func() ([]PartnerRow, error) {
+			return fetchPartnerBatch(ctx, db, lastID, batchSize)
+		})
 		if err != nil {
 			logErrorJSON("partner_fetch_batch", map[string]interface{}{
 				"last_partner_id": lastID,
@@ -322,57 +1048,184 @@ func migratePartnerRemoveTag(ctx context.Context, db *sqlx.DB, dryRun bool, batc
 		batchNum++
 		log.Printf("[PARTNER] batch #%d, size=%d, partner_id range %d..%d",
 			batchNum, len(rows), rows[0].PartnerID, rows[len(rows)-1].PartnerID)
+		updatedBefore := totalUpdated
+		bspan.start(ctx, "partner", batchNum, rows[0].PartnerID, rows[len(rows)-1].PartnerID, len(rows))
 
-		for _, r := range rows {
+		if shadowApply {
+			ids := make([]int64, 0, len(rows))
+			for _, r := range rows {
+				ids = append(ids, r.PartnerID)
+			}
+			if err := copyToShadow(ctx, db, "partner", "partner_id", ids); err != nil {
+				return fmt.Errorf("copy partner batch to shadow: %w", err)
+			}
+		}
+
+		if err := btx.begin(rowCtx); err != nil {
+			return fmt.Errorf("begin partner batch: %w", err)
+		}
+		for i, r := range rows {
+			if i%ctxCheckEvery == 0 && ctx.Err() != nil {
+				log.Printf("[PARTNER] interrupted mid-batch, last processed partner_id=%d", lastID)
+				break batches
+			}
 			totalRows++
 			lastID = r.PartnerID
 
-			updated, skipped, err := processPartnerRowRemoveTag(ctx, db, r, dryRun)
+			if !stats.inCanary(intPK(r.PartnerID)) {
+				totalSkipped++
+				continue
+			}
+
+			if partnerStream {
+				if err := loadPartnerMeta(rowCtx, db, &r); err != nil {
+					log.Printf("[PARTNER][ERROR] partner_id=%d: load meta: %v", r.PartnerID, err)
+					bspan.recordError(err)
+					logErrorJSON("partner_load_meta", map[string]interface{}{
+						"partner_id": r.PartnerID,
+					}, err)
+					continue
+				}
+			}
+
+			updated, skipped, err := processPartnerRowRemoveTag(btx.rowContext(rowCtx), btx.exec(), r, stats, dryRun)
+			if errors.Is(err, errMaxWritesReached) {
+				totalRows--
+				log.Printf("[PARTNER] MAX_WRITES=%d reached, stopping at partner_id=%d (not processed)", maxWrites, r.PartnerID)
+				stoppedAt = r.PartnerID
+				break batches
+			}
 			if err != nil {
 				log.Printf("[PARTNER][ERROR] partner_id=%d: %v", r.PartnerID, err)
+				bspan.recordError(err)
 				logErrorJSON("partner_process_row", map[string]interface{}{
 					"partner_id": r.PartnerID,
 					"dry_run":    dryRun,
 				}, err)
+				logRowContext("PARTNER", map[string]interface{}{
+					"partner_id": r.PartnerID,
+					"meta":       nullableValue(r.Meta.Valid, r.Meta.String),
+				}, err)
 				continue
 			}
 			if updated {
 				totalUpdated++
+				if err := btx.rowUpdated(rowCtx, intPK(r.PartnerID)); err != nil {
+					return err
+				}
 			}
 			if skipped {
 				totalSkipped++
 			}
 		}
+		if err := btx.commit(); err != nil {
+			return err
+		}
+		recordProgress(rowCtx, db, "partner", batchNum, rows[0].PartnerID, rows[len(rows)-1].PartnerID, totalUpdated-updatedBefore, dryRun)
+		bspan.end(totalUpdated - updatedBefore)
+		sleepBetweenBatches(ctx, "PARTNER")
+	}
+
+	if err := btx.commit(); err != nil {
+		return err
 	}
 
 	log.Printf("[PARTNER][SUMMARY] totalRows=%d totalUpdated=%d totalSkipped=%d", totalRows, totalUpdated, totalSkipped)
+	log.Printf("[PARTNER][SUMMARY] urlsCleaned=%d rowsUpdated=%d (dryRun=%v)", stats.urlsCleaned, totalUpdated, dryRun)
+	recordSummary("partner", totalRows, totalUpdated, totalSkipped, stats.urlsCleaned)
+	stats.logSummary(ctx, "PARTNER", "partner_id")
+
+	if stoppedAt != 0 {
+		return fmt.Errorf("partner migration stopped at partner_id=%d: %w", stoppedAt, errMaxWritesReached)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("partner migration interrupted after partner_id=%d: %w", lastID, context.Cause(ctx))
+	}
 	return nil
 }
 
+// partnerJSONPrefilterSQL narrows partner rows to those whose partner_pos_attach_files
+// holds at least one string containing "tag" (covers both tag= and tagging=).
+// JSON_VALID guards against invalid meta, which would otherwise make JSON_SEARCH fail
+// the whole batch. Go still does the authoritative cleaning.
+const partnerJSONPrefilterSQL = `
+    AND (CASE WHEN JSON_VALID(meta)
+        THEN JSON_SEARCH(meta, 'one', '%tag%', NULL, '$.partner_pos_attach_files[*]')
+    END) IS NOT NULL`
+
 func fetchPartnerBatch(ctx context.Context, db *sqlx.DB, lastID int64, limit int) ([]PartnerRow, error) {
+	if usesIDList("partner") {
+		var rows []PartnerRow
+		err := selectByIDs(ctx, db, &rows, "partner", "partner_id", "partner_id, "+partnerMetaSelect(), lastID, limit, func() int { return len(rows) })
+		return rows, err
+	}
+
+	query, args := partnerBatchQuery(ctx, lastID, limit)
+	var rows []PartnerRow
+	if err := db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, &DBError{Op: "select partner", Err: err}
+	}
+	return clipToShard(ctx, rows, func(r PartnerRow) int64 { return r.PartnerID }), nil
+}
+
+// partnerBatchQuery builds the partner candidate SELECT for the batch after lastID, within the
+// shard of ctx.
+func partnerBatchQuery(ctx context.Context, lastID int64, limit int) (string, []interface{}) {
+	shardSQL, shardArgs := shardEndSQL(ctx, "partner_id")
+	modifiedSQL, modifiedArgs := modifiedSinceSQL("partner")
+	prefilter := ""
+	if partnerJSONPrefilter {
+		prefilter = partnerJSONPrefilterSQL
+	}
 	query := `
 SELECT
     partner_id,
-    meta
+    ` + partnerMetaSelect() + `
 FROM partner
 WHERE
-    partner_id > ?
-    AND partner_is_banned != 1
-    AND partner_contract_end >= NOW()
+    partner_id > ?` + shardSQL + `
+    AND ` + eligibleSQL("partner") + prefilter + markFilterSQL("partner") + modifiedSQL + `
 ORDER BY partner_id ASC
 LIMIT ?
 `
-	var rows []PartnerRow
-	if err := db.SelectContext(ctx, &rows, query, lastID, limit); err != nil {
-		return nil, err
+	args := append(append([]interface{}{lastID}, shardArgs...), modifiedArgs...)
+	return query, append(args, limit)
+}
+
+// partnerStream (PARTNER_STREAM=1) keeps at most one partner meta in memory: batches only fetch
+// the pks and each row's meta is loaded right before it is processed (see loadPartnerMeta).
+// This trades one pk lookup per row for a much lower peak RSS on large meta values.
+var partnerStream bool
+
+// partnerMetaSelect is the meta column of the batch SELECT list; a NULL placeholder when streaming.
+func partnerMetaSelect() string {
+	if partnerStream {
+		return "NULL AS meta"
 	}
-	return rows, nil
+	return "meta"
+}
+
+// loadPartnerMeta fills row.Meta with the current value from the database.
+func loadPartnerMeta(ctx context.Context, db *sqlx.DB, row *PartnerRow) error {
+	if err := db.GetContext(ctx, &row.Meta, `SELECT meta FROM partner WHERE partner_id = ?`, row.PartnerID); err != nil {
+		return &DBError{Op: "select partner meta", Err: err}
+	}
+	return nil
+}
+
+// checkJSONSearchSupport probes the server for the JSON functions used by the partner prefilter
+// (MySQL 5.7+/8).
+func checkJSONSearchSupport(ctx context.Context, db *sqlx.DB) error {
+	var found sql.NullString
+	return db.GetContext(ctx, &found,
+		`SELECT JSON_SEARCH(CAST('{"a":["x?tag=1"]}' AS JSON), 'one', '%tag%', NULL, '$.a[*]')`)
 }
 
 func processPartnerRowRemoveTag(
 	ctx context.Context,
-	db *sqlx.DB,
+	db sqlx.ExtContext,
 	row PartnerRow,
+	stats *migrationStats,
 	dryRun bool,
 ) (updated bool, skipped bool, err error) {
 	if !row.Meta.Valid {
@@ -383,74 +1236,183 @@ func processPartnerRowRemoveTag(
 	if rawMeta == "" {
 		return false, true, nil
 	}
+	if partnerMetaMaxBytes > 0 && len(rawMeta) > partnerMetaMaxBytes {
+		detail := fmt.Sprintf("meta is %d bytes, PARTNER_META_MAX_BYTES=%d", len(rawMeta), partnerMetaMaxBytes)
+		log.Printf("[PARTNER][SKIP] partner_id=%d reason=%s: %s", row.PartnerID, skipOversizedMeta, detail)
+		stats.skip(skipOversizedMeta)
+		quarantineRow("partner", "partner_id", intPK(row.PartnerID), dryRun, skipOversizedMeta, detail, map[string]string{"meta": row.Meta.String})
+		return false, true, nil
+	}
 
-	var metaMap map[string]interface{}
-	if err := json.Unmarshal([]byte(rawMeta), &metaMap); err != nil {
+	var (
+		fileURLs, removed []string
+		filtered          bool
+		parseErr, hostErr error
+	)
+	newMeta, cleanedFiles, err := cleanPartnerMeta(rawMeta, func(s string) (string, bool) {
+		fileURLs = append(fileURLs, s)
+		if reason := urlFilterSkipReason(s); reason != "" {
+			log.Printf("[PARTNER][SKIP] partner_id=%d file reason=%s", row.PartnerID, reason)
+			stats.skip(reason)
+			filtered = true
+			return s, false
+		}
+		if err := checkParseableURL(s); err != nil && parseErr == nil {
+			parseErr = err
+		}
+		if err := checkExpectedHost(s); err != nil && hostErr == nil {
+			hostErr = err
+		}
+		newURL, changed := cleanURL(s)
+		if changed {
+			removed = append(removed, removedTagPairs(s, newURL)...)
+		}
+		return newURL, changed
+	})
+	stats.addRowHosts(fileURLs...)
+	stats.trackLongest(intPK(row.PartnerID), fileURLs...)
+	if errors.Is(err, errInvalidPartnerMeta) {
 		log.Printf("[PARTNER][WARN] partner_id=%d invalid JSON meta, skip: %v", row.PartnerID, err)
+		stats.skip(skipInvalidMeta)
+		quarantineRow("partner", "partner_id", intPK(row.PartnerID), dryRun, skipInvalidMeta, err.Error(), map[string]string{"meta": row.Meta.String})
+		return false, true, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if parseErr != nil && quarantinePath != "" {
+		// With quarantine on, a row holding an unparseable file URL is left for review as a whole.
+		log.Printf("[PARTNER][SKIP] partner_id=%d reason=%s: %v", row.PartnerID, skipUnparseableURL, parseErr)
+		stats.skip(skipUnparseableURL)
+		quarantineRow("partner", "partner_id", intPK(row.PartnerID), dryRun, skipUnparseableURL, parseErr.Error(), map[string]string{"meta": row.Meta.String})
 		return false, true, nil
 	}
+	if hostErr != nil {
+		log.Printf("[PARTNER][SKIP] partner_id=%d reason=%s: %v", row.PartnerID, skipUnexpectedHost, hostErr)
+		stats.skip(skipUnexpectedHost)
+		quarantineRow("partner", "partner_id", intPK(row.PartnerID), dryRun, skipUnexpectedHost, hostErr.Error(), map[string]string{"meta": row.Meta.String})
+		return false, true, nil
+	}
+	if cleanedFiles == 0 {
+		// Only the JSON prefilter claims in SQL that the row holds a tag; a pk list bypasses it.
+		if partnerJSONPrefilter && !usesIDList("partner") {
+			recordEligibleUnchanged("partner", "partner_id", row.PartnerID, map[string]string{"meta": row.Meta.String})
+		}
+		if !filtered {
+			stats.skip(skipAlreadyClean)
+		}
+		return false, true, nil
+	}
+	if stats.alreadyClean(rawMeta, newMeta) {
+		return false, true, nil
+	}
+	if partnerMetaVerify {
+		if err := checkPartnerMeta(rawMeta, newMeta); err != nil {
+			log.Printf("[PARTNER][CRITICAL] partner_id=%d not written: %v\nold=%s\nnew=%s", row.PartnerID, err, rawMeta, newMeta)
+			logErrorJSON("partner_meta_verify", map[string]interface{}{"partner_id": row.PartnerID}, err)
+			stats.skip(skipMetaVerifyFailed)
+			quarantineRow("partner", "partner_id", intPK(row.PartnerID), dryRun, skipMetaVerifyFailed, err.Error(), map[string]string{"meta": row.Meta.String})
+			return false, true, nil
+		}
+	}
 
-	val, ok := metaMap["partner_pos_attach_files"]
-	if !ok {
+	rec := newChangeRecord("partner", "partner_id", intPK(row.PartnerID), dryRun)
+	rec.addColumn("meta", row.Meta.String, newMeta, removed...)
+	if wouldTruncate("PARTNER", rec, stats) {
 		return false, true, nil
 	}
 
-	files, ok := val.([]interface{})
+	if dryRun {
+		stats.urlsCleaned += cleanedFiles
+		stats.addChangedHosts(rec)
+		recordChange(rec)
+		if err := stats.verifyDryRunMatch(ctx, db, "PARTNER", rec); err != nil {
+			return false, false, err
+		}
+		if !emitDryRunNDJSON(rec) {
+			log.Printf("[PARTNER][DRY-RUN] partner_id=%d meta\nold=%s\nnew=%s", row.PartnerID, rawMeta, newMeta)
+		}
+		return false, false, nil
+	}
+
+	if err := applyChange(ctx, rec, func() error { return updatePartnerMeta(ctx, db, row.PartnerID, newMeta) }); err != nil {
+		return false, false, withNewValue(fmt.Errorf("apply change: %w", err), newMeta)
+	}
+
+	stats.urlsCleaned += cleanedFiles
+	stats.addChangedHosts(rec)
+	log.Printf("[PARTNER][OK] partner_id=%d updated meta (partner_pos_attach_files cleaned)", row.PartnerID)
+	return true, false, nil
+}
+
+// errInvalidPartnerMeta marks partner meta that is not a JSON object; such rows are skipped.
+var errInvalidPartnerMeta = errors.New("invalid partner meta JSON")
+
+// marshalPartnerMeta re-serializes cleaned partner meta; tests swap it to inject corruption
+// that the PARTNER_META_VERIFY self-check must catch.
+var marshalPartnerMeta = json.Marshal
+
+// cleanPartnerMeta applies clean to every string in meta.partner_pos_attach_files and returns the
+// re-marshalled meta and the number of files changed (0 means nothing to write; newMeta is then
+// empty). It has no DB, logging or stats side effects, so it can be exercised and benchmarked on
+// its own.
+func cleanPartnerMeta(rawMeta string, clean func(string) (string, bool)) (newMeta string, cleaned int, err error) {
+	var metaMap map[string]interface{}
+	if err := json.Unmarshal([]byte(rawMeta), &metaMap); err != nil {
+		return "", 0, fmt.Errorf("%w: %v", errInvalidPartnerMeta, err)
+	}
+
+	files, ok := metaMap["partner_pos_attach_files"].([]interface{})
 	if !ok || len(files) == 0 {
-		return false, true, nil
+		return "", 0, nil
 	}
 
-	changed := false
 	newFiles := make([]interface{}, 0, len(files))
-
 	for _, item := range files {
 		s, ok := item.(string)
 		if !ok {
 			newFiles = append(newFiles, item)
 			continue
 		}
-		newURL, modified := removeTagParamsFromURL(s)
+		newURL, modified := clean(s)
 		if modified {
-			changed = true
+			cleaned++
 			newFiles = append(newFiles, newURL)
 		} else {
 			newFiles = append(newFiles, s)
 		}
 	}
 
-	if !changed {
-		return false, true, nil
+	if cleaned == 0 {
+		return "", 0, nil
 	}
 
 	metaMap["partner_pos_attach_files"] = newFiles
 
-	newMetaBytes, err := json.Marshal(metaMap)
+	newMetaBytes, err := marshalPartnerMeta(metaMap)
 	if err != nil {
-		return false, false, fmt.Errorf("marshal updated meta: %w", err)
+		return "", 0, fmt.Errorf("marshal updated meta: %w", err)
 	}
-	newMeta := string(newMetaBytes)
-
-	if dryRun {
-		log.Printf("[PARTNER][DRY-RUN] partner_id=%d meta\nold=%s\nnew=%s", row.PartnerID, rawMeta, newMeta)
-		return false, false, nil
-	}
-
-	if err := updatePartnerMeta(ctx, db, row.PartnerID, newMeta); err != nil {
-		return false, false, fmt.Errorf("update DB: %w", err)
-	}
-
-	log.Printf("[PARTNER][OK] partner_id=%d updated meta (partner_pos_attach_files cleaned)", row.PartnerID)
-	return true, false, nil
+	return string(newMetaBytes), cleaned, nil
 }
 
-func updatePartnerMeta(ctx context.Context, db *sqlx.DB, partnerID int64, newMeta string) error {
-	query := `
-UPDATE partner
-SET meta = ?
+func updatePartnerMeta(ctx context.Context, db sqlx.ExecerContext, partnerID int64, newMeta string) error {
+	query := fmt.Sprintf(`
+UPDATE %s
+SET meta = ?%s
 WHERE partner_id = ?
-`
+`, targetTable("partner"), markSetSQL("partner"))
+	if err := reserveWrite(); err != nil {
+		return err
+	}
+	if copyMode {
+		return writeCleanedRow(ctx, db, "partner", partnerID, map[string]string{"meta": newMeta})
+	}
 	_, err := db.ExecContext(ctx, query, newMeta, partnerID)
-	return err
+	if err != nil {
+		return &DBError{Op: "update partner", Err: err}
+	}
+	return nil
 }
 
 // ------------------------------
@@ -461,22 +1423,39 @@ func migrateClientRemoveTag(ctx context.Context, db *sqlx.DB, dryRun bool, batch
 	log.Println("== CLIENT: start remove tagging in attachment URLs ==")
 
 	var (
-		lastID       int64
+		lastID       = shardStart(ctx)
 		batchNum     int
 		totalRows    int
 		totalUpdated int
 		totalSkipped int
+		stoppedAt    int64
 	)
-
-	like := hydraSignPrefix + "%"
-
+	stats := newMigrationStats()
+	// Rows already started finish their write even if ctx is cancelled; the loop stops at the next check.
+	rowCtx := context.WithoutCancel(ctx)
+	btx := newBatchTx(db, "CLIENT")
+	var bspan batchSpan
+	defer bspan.abort()
+
+batches:
 	for {
-		rows, err := fetchClientBatch(ctx, db, lastID, batchSize, like)
+		if ctx.Err() != nil {
+			break
+		}
+		waitWhilePaused(ctx, "CLIENT")
+		waitForReplication(ctx, "CLIENT")
+		if ctx.Err() != nil {
+			break
+		}
+
+		rows, err := fetchWithRetry(ctx, "CLIENT", func() ([]ClientRow, error) {
+			return fetchClientBatch(ctx, db, lastID, batchSize)
+		})
 		if err != nil {
 			logErrorJSON("client_fetch_batch", map[string]interface{}{
 				"last_client_id": lastID,
 				"batch_size":     batchSize,
-				"like_prefix":    like,
+				"prefixes":       hydraSignPrefixes,
 			}, err)
 			return fmt.Errorf("fetch client batch: %w", err)
 		}
@@ -488,65 +1467,214 @@ func migrateClientRemoveTag(ctx context.Context, db *sqlx.DB, dryRun bool, batch
 		batchNum++
 		log.Printf("[CLIENT] batch #%d, size=%d, client_id range %d..%d",
 			batchNum, len(rows), rows[0].ClientID, rows[len(rows)-1].ClientID)
+		updatedBefore := totalUpdated
+		bspan.start(ctx, "client", batchNum, rows[0].ClientID, rows[len(rows)-1].ClientID, len(rows))
 
-		for _, r := range rows {
+		if shadowApply {
+			ids := make([]int64, 0, len(rows))
+			for _, r := range rows {
+				ids = append(ids, r.ClientID)
+			}
+			if err := copyToShadow(ctx, db, "client", "client_id", ids); err != nil {
+				return fmt.Errorf("copy client batch to shadow: %w", err)
+			}
+		}
+
+		if err := btx.begin(rowCtx); err != nil {
+			return fmt.Errorf("begin client batch: %w", err)
+		}
+		for i, r := range rows {
+			if i%ctxCheckEvery == 0 && ctx.Err() != nil {
+				log.Printf("[CLIENT] interrupted mid-batch, last processed client_id=%d", lastID)
+				break batches
+			}
 			totalRows++
 			lastID = r.ClientID
 
-			updated, skipped, err := processClientRowRemoveTag(ctx, db, r, dryRun)
+			if !stats.inCanary(intPK(r.ClientID)) {
+				totalSkipped++
+				continue
+			}
+
+			updated, skipped, err := processClientRowRemoveTag(btx.rowContext(rowCtx), btx.exec(), r, stats, dryRun)
+			if errors.Is(err, errMaxWritesReached) {
+				totalRows--
+				log.Printf("[CLIENT] MAX_WRITES=%d reached, stopping at client_id=%d (not processed)", maxWrites, r.ClientID)
+				stoppedAt = r.ClientID
+				break batches
+			}
 			if err != nil {
 				log.Printf("[CLIENT][ERROR] client_id=%d: %v", r.ClientID, err)
+				bspan.recordError(err)
 				logErrorJSON("client_process_row", map[string]interface{}{
 					"client_id": r.ClientID,
 					"dry_run":   dryRun,
 				}, err)
+				logRowContext("CLIENT", map[string]interface{}{
+					"client_id":                      r.ClientID,
+					"client_contract_attachment_url": nullableValue(r.ClientContractAttachment.Valid, r.ClientContractAttachment.String),
+					"client_tax_attachment":          nullableValue(r.ClientTaxAttachment.Valid, r.ClientTaxAttachment.String),
+					"client_pks_attachment":          nullableValue(r.ClientPksAttachment.Valid, r.ClientPksAttachment.String),
+				}, err)
 				continue
 			}
 			if updated {
 				totalUpdated++
+				if err := btx.rowUpdated(rowCtx, intPK(r.ClientID)); err != nil {
+					return err
+				}
 			}
 			if skipped {
 				totalSkipped++
 			}
 		}
+		if err := btx.commit(); err != nil {
+			return err
+		}
+		recordProgress(rowCtx, db, "client", batchNum, rows[0].ClientID, rows[len(rows)-1].ClientID, totalUpdated-updatedBefore, dryRun)
+		bspan.end(totalUpdated - updatedBefore)
+		sleepBetweenBatches(ctx, "CLIENT")
+	}
+
+	if err := btx.commit(); err != nil {
+		return err
 	}
 
 	log.Printf("[CLIENT][SUMMARY] totalRows=%d totalUpdated=%d totalSkipped=%d", totalRows, totalUpdated, totalSkipped)
+	log.Printf("[CLIENT][SUMMARY] urlsCleaned=%d rowsUpdated=%d (dryRun=%v)", stats.urlsCleaned, totalUpdated, dryRun)
+	recordSummary("client", totalRows, totalUpdated, totalSkipped, stats.urlsCleaned)
+	stats.logSummary(ctx, "CLIENT", "client_id")
+
+	if stoppedAt != 0 {
+		return fmt.Errorf("client migration stopped at client_id=%d: %w", stoppedAt, errMaxWritesReached)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("client migration interrupted after client_id=%d: %w", lastID, context.Cause(ctx))
+	}
 	return nil
 }
 
-func fetchClientBatch(ctx context.Context, db *sqlx.DB, lastID int64, limit int, likePrefix string) ([]ClientRow, error) {
+// clientAttachmentColumns are the client columns holding hydra attachment URLs. Columns missing
+// from the database are removed at startup (see adaptToSchema).
+var clientAttachmentColumns = []string{
+	"client_contract_attachment_url",
+	"client_tax_attachment",
+	"client_pks_attachment",
+}
+
+func fetchClientBatch(ctx context.Context, db *sqlx.DB, lastID int64, limit int) ([]ClientRow, error) {
+	if usesIDList("client") {
+		var rows []ClientRow
+		err := selectByIDs(ctx, db, &rows, "client", "client_id", "client_id, "+strings.Join(clientSelectColumns(), ", "),
+			lastID, limit, func() int { return len(rows) })
+		return rows, err
+	}
+
+	query, args := clientBatchQuery(ctx, lastID, limit)
+	var rows []ClientRow
+	if err := db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, &DBError{Op: "select client", Err: err}
+	}
+	return clipToShard(ctx, rows, func(r ClientRow) int64 { return r.ClientID }), nil
+}
+
+// clientBatchQuery builds the client candidate SELECT for the batch after lastID, within the
+// shard of ctx.
+func clientBatchQuery(ctx context.Context, lastID int64, limit int) (string, []interface{}) {
+	shardSQL, shardArgs := shardEndSQL(ctx, "client_id")
+	likeSQL, likeArgs := clientHydraLikeSQL()
+	modifiedSQL, modifiedArgs := modifiedSinceSQL("client")
 	query := `
 SELECT
     client_id,
-    client_contract_attachment_url,
-    client_tax_attachment,
-    client_pks_attachment
+    ` + strings.Join(clientSelectColumns(), ",\n    ") + `
 FROM client
 WHERE
-    client_id > ?
-    AND (
-        client_contract_attachment_url LIKE ? OR
-        client_tax_attachment LIKE ? OR
-        client_pks_attachment LIKE ?
-    ) AND client_is_banned != 1 AND client_contract_end_date >= NOW()
+    client_id > ?` + shardSQL + `
+    AND ` + likeSQL + `
+    AND ` + clientEligibleSQL() + modifiedSQL + `
 ORDER BY client_id ASC
 LIMIT ?
 `
-	var rows []ClientRow
-	if err := db.SelectContext(ctx, &rows, query, lastID, likePrefix, likePrefix, likePrefix, limit); err != nil {
-		return nil, err
+	args := make([]interface{}, 0, len(shardArgs)+len(likeArgs)+len(modifiedArgs)+2)
+	args = append(args, lastID)
+	args = append(args, shardArgs...)
+	args = append(args, likeArgs...)
+	args = append(args, modifiedArgs...)
+	args = append(args, limit)
+	return query, args
+}
+
+// clientHydraLikeSQL is the hydra prefilter: every attachment column is matched against every
+// known hydra prefix, both as a plain URL and as the first element of a JSON array of URLs:
+// ["<prefix>...", ...]. Go re-checks each URL with hasHydraPrefix (see MODE=prefix-audit).
+func clientHydraLikeSQL() (string, []interface{}) {
+	var (
+		likeParts []string
+		likeArgs  []interface{}
+	)
+	for _, col := range clientAttachmentColumns {
+		for _, prefix := range hydraSignPrefixes {
+			likeParts = append(likeParts, col+" LIKE ?", col+" LIKE ?")
+			likeArgs = append(likeArgs, prefix+"%", `["`+prefix+"%")
+		}
 	}
-	return rows, nil
+	return "(\n        " + strings.Join(likeParts, " OR\n        ") + "\n    )", likeArgs
+}
+
+// clientEligibleSQL is the non-URL part of the client candidate filter.
+func clientEligibleSQL() string {
+	return eligibleSQL("client") + markFilterSQL("client")
 }
 
 func processClientRowRemoveTag(
 	ctx context.Context,
-	db *sqlx.DB,
+	db sqlx.ExtContext,
 	row ClientRow,
+	stats *migrationStats,
 	dryRun bool,
 ) (updated bool, skipped bool, err error) {
 	updates := make(map[string]string)
+	oldValues := make(map[string]sql.NullString)
+	removed := make(map[string][]string)
+	var (
+		resignErr error
+		parseErr  error
+		hostErr   error
+		filtered  bool
+	)
+
+	cleanOne := func(col, raw string) (string, bool) {
+		// Hanya sentuh hydra URLs (safety)
+		if !hasHydraPrefix(raw) {
+			return raw, false
+		}
+		if reason := urlFilterSkipReason(raw); reason != "" {
+			log.Printf("[CLIENT][SKIP] client_id=%d %s reason=%s", row.ClientID, col, reason)
+			stats.skip(reason)
+			filtered = true
+			return raw, false
+		}
+		if err := checkParseableURL(raw); err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("%s: %w", col, err)
+		}
+		if err := checkExpectedHost(raw); err != nil && hostErr == nil {
+			hostErr = fmt.Errorf("%s: %w", col, err)
+		}
+		newURL, changed := cleanURL(raw)
+		if changed {
+			removed[col] = append(removed[col], removedTagPairs(raw, newURL)...)
+		}
+		if changed && resigns("client", col) && resignErr == nil {
+			signed, err := resignURL(ctx, newURL)
+			if err != nil {
+				resignErr = fmt.Errorf("%s: %w", col, err)
+				return raw, false
+			}
+			newURL = signed
+		}
+		return newURL, changed
+	}
 
 	handleCol := func(col string, v sql.NullString) {
 		if !v.Valid {
@@ -556,104 +1684,424 @@ func processClientRowRemoveTag(
 		if raw == "" {
 			return
 		}
-		// Hanya sentuh hydra URLs (safety)
-		if !strings.HasPrefix(raw, hydraSignPrefix) {
-			return
+
+		newValue, changed, isArray := cleanURLArray(raw, func(u string) (string, bool) {
+			return cleanOne(col, u)
+		})
+		if !isArray {
+			newValue, changed = cleanOne(col, raw)
 		}
-		newURL, changed := removeTagParamsFromURL(raw)
-		if changed {
-			updates[col] = newURL
+		// Compared with the column that is written: a mapped read column stays tagged, so on a
+		// rerun its write column already holding newValue makes the column already clean.
+		old, current := v, raw
+		if _, mapped := clientWriteColumns[col]; mapped {
+			old = row.writtenValue(col)
+			current = old.String
 		}
+		if changed && (!old.Valid || newValue != current) {
+			updates[col] = newValue
+			oldValues[col] = old
+		}
+	}
+
+	var hostURLs []string
+	for _, v := range []sql.NullString{row.ClientContractAttachment, row.ClientTaxAttachment, row.ClientPksAttachment} {
+		hostURLs = append(hostURLs, columnURLs(v.String)...)
 	}
+	stats.addRowHosts(hostURLs...)
+	stats.trackLongest(intPK(row.ClientID), hostURLs...)
 
 	handleCol("client_contract_attachment_url", row.ClientContractAttachment)
 	handleCol("client_tax_attachment", row.ClientTaxAttachment)
 	handleCol("client_pks_attachment", row.ClientPksAttachment)
 
+	rowValues := map[string]string{
+		"client_contract_attachment_url": row.ClientContractAttachment.String,
+		"client_tax_attachment":          row.ClientTaxAttachment.String,
+		"client_pks_attachment":          row.ClientPksAttachment.String,
+	}
+	if parseErr != nil && quarantinePath != "" {
+		// With quarantine on, a row holding an unparseable URL is left for review as a whole.
+		log.Printf("[CLIENT][SKIP] client_id=%d reason=%s: %v", row.ClientID, skipUnparseableURL, parseErr)
+		stats.skip(skipUnparseableURL)
+		quarantineRow("client", "client_id", intPK(row.ClientID), dryRun, skipUnparseableURL, parseErr.Error(), rowValues)
+		return false, true, nil
+	}
+	if hostErr != nil {
+		log.Printf("[CLIENT][SKIP] client_id=%d reason=%s: %v", row.ClientID, skipUnexpectedHost, hostErr)
+		stats.skip(skipUnexpectedHost)
+		quarantineRow("client", "client_id", intPK(row.ClientID), dryRun, skipUnexpectedHost, hostErr.Error(), rowValues)
+		return false, true, nil
+	}
+	if resignErr != nil {
+		// A partly re-signed row is not written; the whole row waits for the next run.
+		log.Printf("[CLIENT][SKIP] client_id=%d reason=%s err=%v", row.ClientID, skipResignFailed, resignErr)
+		stats.skip(skipResignFailed)
+		return false, true, nil
+	}
+
 	if len(updates) == 0 {
+		if !usesIDList("client") {
+			// The row matched the hydra LIKE prefilter, yet nothing was cleaned. A pk list
+			// (IDS_FILE, MODE=reclean) is fetched without the prefilter, so it claims nothing.
+			recordEligibleUnchanged("client", "client_id", row.ClientID, rowValues)
+		}
+		if !filtered {
+			// Every column already holds what this run would write.
+			stats.skip(skipAlreadyClean)
+		}
+		return false, true, nil
+	}
+
+	rec := newChangeRecord("client", "client_id", intPK(row.ClientID), dryRun)
+	for col, newURL := range updates {
+		// Recorded under the column actually written, with that column's previous value, so
+		// the rollback restores it (see CLIENT_COLUMN_MAP).
+		rec.addNullableColumn(clientWriteColumn(col), oldValues[col], newURL, removed[col]...)
+	}
+	if wouldTruncate("CLIENT", rec, stats) {
 		return false, true, nil
 	}
 
 	if dryRun {
-		log.Printf("[CLIENT][DRY-RUN] client_id=%d DB updates: %+v", row.ClientID, updates)
+		stats.urlsCleaned += len(updates)
+		stats.addChangedHosts(rec)
+		recordChange(rec)
+		if err := stats.verifyDryRunMatch(ctx, db, "CLIENT", rec); err != nil {
+			return false, false, err
+		}
+		if !emitDryRunNDJSON(rec) {
+			log.Printf("[CLIENT][DRY-RUN] client_id=%d DB updates: %+v", row.ClientID, updates)
+		}
 		return false, false, nil
 	}
 
-	if err := applyClientUpdates(ctx, db, row.ClientID, updates); err != nil {
-		return false, false, fmt.Errorf("update DB: %w", err)
+	if err := applyChange(ctx, rec, func() error { return applyClientUpdates(ctx, db, row.ClientID, updates) }); err != nil {
+		return false, false, withNewValue(fmt.Errorf("apply change: %w", err), updates)
 	}
 
+	stats.urlsCleaned += len(updates)
+	stats.addChangedHosts(rec)
 	log.Printf("[CLIENT][OK] client_id=%d updated columns: %s", row.ClientID, strings.Join(mapKeys(updates), ", "))
 	return true, false, nil
 }
 
-func applyClientUpdates(ctx context.Context, db *sqlx.DB, clientID int64, updates map[string]string) error {
+// applyClientUpdates writes updates (keyed by read column) to their write columns in one UPDATE.
+func applyClientUpdates(ctx context.Context, db sqlx.ExecerContext, clientID int64, updates map[string]string) error {
 	if len(updates) == 0 {
 		return nil
 	}
 
 	setParts := make([]string, 0, len(updates))
 	args := make([]interface{}, 0, len(updates)+1)
+	values := make(map[string]string, len(updates))
 
 	for col, val := range updates {
-		setParts = append(setParts, fmt.Sprintf("%s = ?", col))
+		setParts = append(setParts, fmt.Sprintf("%s = ?", clientWriteColumn(col)))
 		args = append(args, val)
+		values[clientWriteColumn(col)] = val
 	}
 
 	args = append(args, clientID)
 
-	query := fmt.Sprintf(`UPDATE client SET %s WHERE client_id = ?`, strings.Join(setParts, ", "))
+	query := fmt.Sprintf(`UPDATE %s SET %s%s WHERE client_id = ?`, targetTable("client"), strings.Join(setParts, ", "), markSetSQL("client"))
+	if err := reserveWrite(); err != nil {
+		return err
+	}
+	if copyMode {
+		return writeCleanedRow(ctx, db, "client", clientID, values)
+	}
 	_, err := db.ExecContext(ctx, query, args...)
-	return err
+	if err != nil {
+		return &DBError{Op: "update client", Err: err}
+	}
+	return nil
 }
 
 // ------------------------------
 // URL helper
 // ------------------------------
 
-// removeTagParamsFromURL removes "tag" and "tagging" query params if present.
+// cleanURL runs the full cleaning chain on one URL: tag removal (verified by the optional
+// POST_CHECK_PARAMS), then the optional FORCE_HTTPS upgrade. Returns (newURL, changed) where changed is true if any step altered it.
+// A STORAGE_PREFIXES wrapper (e.g. "url:") is stripped first and put back around the result.
+func cleanURL(rawURL string) (string, bool) {
+	if prefix, inner, ok := cutStoragePrefix(rawURL); ok {
+		newInner, changed := cleanBareURL(inner)
+		if !changed {
+			return rawURL, false
+		}
+		return prefix + newInner, true
+	}
+	return cleanBareURL(rawURL)
+}
+
+// cleanBareURL is cleanURL for a value without storage prefix.
+func cleanBareURL(rawURL string) (string, bool) {
+	newURL, changed := rawURL, false
+	if !normalizeOnly {
+		newURL, changed = removeTagParamsFromURL(rawURL, removeTagValue)
+	}
+	if changed && postCheckParams {
+		if err := checkParamsPreserved(rawURL, newURL, removeTagValue); err != nil {
+			reportPostCheckViolation(rawURL, newURL, err)
+			return rawURL, false
+		}
+	}
+	if upgraded, ok := forceHTTPSForHost(newURL); ok {
+		newURL, changed = upgraded, true
+	}
+	return newURL, changed
+}
+
+// storagePrefixes (STORAGE_PREFIXES, comma-separated) are wrappers some legacy values carry in
+// front of the actual URL, e.g. "url:https://...". url.Parse would read them as the scheme.
+var storagePrefixes []string
+
+// cutStoragePrefix splits rawURL into its storage prefix and the wrapped URL. The longest
+// matching prefix wins, so "asset://" is preferred over "asset:" when both are configured.
+func cutStoragePrefix(rawURL string) (prefix, inner string, ok bool) {
+	for _, p := range storagePrefixes {
+		if strings.HasPrefix(rawURL, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return "", rawURL, false
+	}
+	return prefix, rawURL[len(prefix):], true
+}
+
+// forceHTTPSForHost upgrades an http:// URL to https:// when FORCE_HTTPS=1 and its host is in
+// FORCE_HTTPS_HOSTS. Only the scheme is rewritten; the rest of the string is kept byte-for-byte.
+func forceHTTPSForHost(rawURL string) (string, bool) {
+	if !forceHTTPS || len(rawURL) < len("http://") || !strings.EqualFold(rawURL[:len("http://")], "http://") {
+		return rawURL, false
+	}
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return rawURL, false
+	}
+	if !forceHTTPSHosts[strings.ToLower(u.Hostname())] {
+		return rawURL, false
+	}
+	return "https://" + rawURL[len("http://"):], true
+}
+
+// removeTagValue is the removal predicate of cleanURL: every tag param by default, only those
+// whose value matches TAG_VALUE_REGEX when it is set.
+func removeTagValue(key, value string) bool {
+	return tagValueRegex == nil || tagValueRegex.MatchString(value)
+}
+
+// removeTagParamsFromURL removes the tagParams ("tag" and "tagging" by default) query params if present
+// and shouldRemove(key, decoded value) allows it; a nil shouldRemove removes them all.
 // Returns (newURL, changed).
-func removeTagParamsFromURL(rawURL string) (string, bool) {
+//
+// Values are decoded before matching, so encoded delimiters inside a value (e.g.
+// ?tag=a%26b&real=1) belong to that value and only the tag pair is dropped. If the query has
+// malformed pairs (bad %-escapes, ';' separators), url.Values would silently drop them on
+// re-encoding, so the tag pairs are cut out of the raw query instead and the rest is kept verbatim.
+//
+// Protocol-relative URLs (//cdn.host/path?tag=x) parse with an empty scheme and a host; they are
+// cleaned like absolute ones and re-serialize with the leading // intact.
+func removeTagParamsFromURL(rawURL string, shouldRemove func(key, value string) bool) (string, bool) {
 	if rawURL == "" {
 		return rawURL, false
 	}
 
-	u, err := url.Parse(rawURL)
+	u, err := parseURL(rawURL)
 	if err != nil {
 		// keep as-is on parse error
 		return rawURL, false
 	}
 
-	q := u.Query()
-	changed := false
-
-	if _, ok := q["tag"]; ok {
-		q.Del("tag")
-		changed = true
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		newQuery, changed := removeRawQueryParams(u.RawQuery, tagParams, shouldRemove)
+		if !changed {
+			return rawURL, false
+		}
+		u.RawQuery = newQuery
+		return u.String(), true
 	}
-	if _, ok := q["tagging"]; ok {
-		q.Del("tagging")
+
+	changed := false
+	for _, key := range tagParams {
+		values, ok := q[key]
+		if !ok {
+			continue
+		}
+		var kept []string
+		for _, v := range values {
+			if shouldRemove != nil && !shouldRemove(key, v) {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == len(values) {
+			continue
+		}
 		changed = true
+		if len(kept) == 0 {
+			q.Del(key)
+		} else {
+			q[key] = kept
+		}
 	}
 
 	if !changed {
 		return rawURL, false
 	}
 
-	u.RawQuery = q.Encode()
+	u.RawQuery = encodeSpaces(q.Encode(), u.RawQuery)
 	return u.String(), true
 }
 
+// encodeSpaces rewrites the spaces of encoded, a url.Values.Encode result, per SPACE_ENCODING.
+// Encode writes spaces as + and a literal + as %2B, so every + in encoded is a space. Without
+// SPACE_ENCODING, %20 is used only if the original query spelled its spaces that way.
+func encodeSpaces(encoded, original string) string {
+	percent := spaceEncoding == "percent" ||
+		(spaceEncoding == "" && strings.Contains(original, "%20") && !strings.Contains(original, "+"))
+	if !percent {
+		return encoded
+	}
+	return strings.ReplaceAll(encoded, "+", "%20")
+}
+
+// removeRawQueryParams drops the &-separated pairs of rawQuery whose (decoded) key is in keys and
+// that shouldRemove (nil = all) allows, keeping every other pair byte-for-byte. A matching pair that also contains ';' is ambiguous
+// (it may hide other params), so the query is then left untouched.
+func removeRawQueryParams(rawQuery string, keys []string, shouldRemove func(key, value string) bool) (string, bool) {
+	drop := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		drop[k] = true
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	kept := make([]string, 0, len(pairs))
+	changed := false
+	for _, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		if !drop[key] || (shouldRemove != nil && !shouldRemove(key, value)) {
+			kept = append(kept, pair)
+			continue
+		}
+		if strings.Contains(pair, ";") {
+			return rawQuery, false
+		}
+		changed = true
+	}
+	if !changed {
+		return rawQuery, false
+	}
+	return strings.Join(kept, "&"), true
+}
+
+// urlFilterSkipReason returns a non-empty skip reason if rawURL is filtered out by
+// URL_INCLUDE_REGEX / URL_EXCLUDE_REGEX.
+func urlFilterSkipReason(rawURL string) string {
+	if urlIncludeRegex != nil && !urlIncludeRegex.MatchString(rawURL) {
+		return "regex-include"
+	}
+	if urlExcludeRegex != nil && urlExcludeRegex.MatchString(rawURL) {
+		return "regex-exclude"
+	}
+	return ""
+}
+
+// hasHydraPrefix reports whether rawURL starts with any of the known hydra sign prefixes.
+// A protocol-relative URL does not match an absolute prefix; list its //host/... form in
+// HYDRA_PREFIXES_FILE to have such values cleaned.
+func hasHydraPrefix(rawURL string) bool {
+	for _, prefix := range hydraSignPrefixes {
+		if strings.HasPrefix(rawURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanURLArray handles a column whose whole value is a JSON array of URLs, e.g.
+// ["https://...?tag=a","https://...?tag=b"]. Each string element is passed to clean and the
+// array is re-marshalled; non-string elements are kept as-is. isArray is false when raw is not
+// a JSON array, in which case the caller should treat it as a single URL.
+func cleanURLArray(raw string, clean func(string) (string, bool)) (newValue string, changed bool, isArray bool) {
+	if !strings.HasPrefix(raw, "[") {
+		return raw, false, false
+	}
+	var items []interface{}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber() // keep numeric elements byte-for-byte
+	if err := dec.Decode(&items); err != nil || dec.More() {
+		return raw, false, false
+	}
+
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			continue
+		}
+		if cleaned, modified := clean(s); modified {
+			items[i] = cleaned
+			changed = true
+		}
+	}
+	if !changed {
+		return raw, false, true
+	}
+
+	// Keep & and friends literal instead of \u0026, matching how the URLs were stored.
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(items); err != nil {
+		return raw, false, true
+	}
+	return strings.TrimSuffix(b.String(), "\n"), true, true
+}
+
+// columnURLs returns the URLs held in a column value: the elements of a JSON array of strings,
+// or the value itself.
+func columnURLs(v string) []string {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "[") {
+		var items []interface{}
+		if err := json.Unmarshal([]byte(v), &items); err == nil {
+			urls := make([]string, 0, len(items))
+			for _, item := range items {
+				if s, ok := item.(string); ok {
+					urls = append(urls, s)
+				}
+			}
+			return urls
+		}
+	}
+	return []string{v}
+}
+
 // ------------------------------
 // Error logging helper
 // ------------------------------
 
-// logErrorJSON writes a single JSON line describing an error to the error log file, if configured.
-// It is best-effort and never panics or returns errors.
+// logErrorJSON writes a single JSON line describing an error to the error log file, if configured,
+// and keeps it for the RECENT_ERRORS recap. It is best-effort and never panics or returns errors.
 func logErrorJSON(kind string, meta map[string]interface{}, err error) {
-	if errorLogEncoder == nil || err == nil {
+	if err == nil {
 		return
 	}
+	rememberError(kind, meta, err)
+	if errorLogEncoder == nil {
+		return
+	}
+	errorLogMu.Lock()
+	defer errorLogMu.Unlock()
 
 	entry := map[string]interface{}{
 		"kind":      kind,
@@ -664,10 +2112,49 @@ func logErrorJSON(kind string, meta map[string]interface{}, err error) {
 	_ = errorLogEncoder.Encode(entry)
 }
 
+// ------------------------------
+// Pause control
+// ------------------------------
+
+// pausePollInterval is how often the pause file is re-checked while paused.
+const pausePollInterval = 5 * time.Second
+
+// waitWhilePaused blocks while PAUSE_FILE exists, logging on every poll so a paused job
+// is distinguishable from a hung one. It returns early if ctx is cancelled.
+func waitWhilePaused(ctx context.Context, label string) {
+	if pauseFile == "" {
+		return
+	}
+	var pausedAt time.Time
+	for {
+		if _, err := os.Stat(pauseFile); err != nil {
+			if !pausedAt.IsZero() {
+				log.Printf("[%s] pause file %s removed, resuming after %s", label, pauseFile, time.Since(pausedAt).Round(time.Second))
+			}
+			return
+		}
+		if pausedAt.IsZero() {
+			pausedAt = time.Now()
+		}
+		log.Printf("[%s] PAUSED: pause file %s exists (paused for %s)", label, pauseFile, time.Since(pausedAt).Round(time.Second))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pausePollInterval):
+		}
+	}
+}
+
 // ------------------------------
 // Utils
 // ------------------------------
 
+// dotEnvKeys holds the env vars set from .env, for reporting where a value came from.
+var dotEnvKeys = make(map[string]bool)
+
+// loadDotEnvFile sets the KEY=value pairs of path that are not already in the environment, so
+// real env vars and command-line flags (see applyFlags) take precedence over .env.
 func loadDotEnvFile(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -696,11 +2183,69 @@ func loadDotEnvFile(path string) error {
 				value = value[1 : len(value)-1]
 			}
 		}
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
 		_ = os.Setenv(key, value)
+		dotEnvKeys[key] = true
 	}
 	return scanner.Err()
 }
 
+// compileEnvRegex compiles the regex in env key, or returns nil if unset.
+func compileEnvRegex(key string) (*regexp.Regexp, error) {
+	pattern := strings.TrimSpace(os.Getenv(key))
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &ConfigError{Key: key, Err: err}
+	}
+	return re, nil
+}
+
+var sqlIdentifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isSQLIdentifier reports whether name is safe to interpolate into SQL as a table/column name.
+func isSQLIdentifier(name string) bool {
+	return sqlIdentifierRe.MatchString(name)
+}
+
+// loadNonNegativeIntFromEnv reads an optional integer >= 0 from env key, falling back to def.
+func loadNonNegativeIntFromEnv(key string, def int) int {
+	val := strings.TrimSpace(os.Getenv(key))
+	if val == "" {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		log.Printf("[WARN] invalid %s=%q, using default=%d", key, val, def)
+		return def
+	}
+	return n
+}
+
+// loadLines reads path and returns its non-empty, non-comment (#) lines, trimmed.
+func loadLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
 func loadBatchSizeFromEnv(key string, def int) int {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
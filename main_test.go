@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// benchURL returns a URL with n extra query params around a tag param.
+func benchURL(n int) string {
+	var b strings.Builder
+	b.WriteString("https://api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?key=contract.pdf")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "&p%d=v%d", i, i)
+		if i == n/2 {
+			b.WriteString("&tag=abc123&tagging=def456")
+		}
+	}
+	return b.String()
+}
+
+// benchMeta returns partner meta with n attachment URLs, every other one tagged.
+func benchMeta(n int) string {
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("https://cdn.example.com/files/%d.pdf?v=%d", i, i)
+		if i%2 == 0 {
+			files[i] += "&tag=t" + fmt.Sprint(i)
+		}
+	}
+	meta, _ := json.Marshal(map[string]interface{}{
+		"partner_pos_attach_files": files,
+		"partner_name":             "Example",
+		"partner_settings":         map[string]interface{}{"limit": 10, "enabled": true},
+	})
+	return string(meta)
+}
+
+func BenchmarkRemoveTagParamsFromURL(b *testing.B) {
+	for _, size := range []struct {
+		name   string
+		params int
+	}{{"small", 2}, {"large", 200}} {
+		raw := benchURL(size.params)
+		b.Run(size.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, changed := removeTagParamsFromURL(raw, nil); !changed {
+					b.Fatal("expected a change")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCleanPartnerMeta(b *testing.B) {
+	for _, size := range []struct {
+		name  string
+		files int
+	}{{"small", 2}, {"large", 500}} {
+		meta := benchMeta(size.files)
+		b.Run(size.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, n, err := cleanPartnerMeta(meta, cleanURL); err != nil || n == 0 {
+					b.Fatalf("cleanPartnerMeta: cleaned=%d err=%v", n, err)
+				}
+			}
+		})
+	}
+}
+
+// withCleaningConfig restores the cleaning globals after the test, starting from the defaults.
+func withCleaningConfig(t *testing.T) {
+	t.Helper()
+	saved := struct {
+		tagParams       []string
+		tagValueRegex   *regexp.Regexp
+		storagePrefixes []string
+		forceHTTPS      bool
+		forceHTTPSHosts map[string]bool
+		spaceEncoding   string
+		normalizeOnly   bool
+		postCheckParams bool
+		bulkS3Prefix    string
+		bulkNoFilename  bool
+		keepEmpty       bool
+	}{tagParams, tagValueRegex, storagePrefixes, forceHTTPS, forceHTTPSHosts, spaceEncoding, normalizeOnly, postCheckParams, bulkS3Prefix, bulkNoFilenameClean, keepEmpty}
+	t.Cleanup(func() {
+		tagParams, tagValueRegex, storagePrefixes = saved.tagParams, saved.tagValueRegex, saved.storagePrefixes
+		forceHTTPS, forceHTTPSHosts = saved.forceHTTPS, saved.forceHTTPSHosts
+		spaceEncoding, normalizeOnly, postCheckParams = saved.spaceEncoding, saved.normalizeOnly, saved.postCheckParams
+		bulkS3Prefix, bulkNoFilenameClean, keepEmpty = saved.bulkS3Prefix, saved.bulkNoFilename, saved.keepEmpty
+	})
+	tagParams = []string{"tag", "tagging"}
+	tagValueRegex = nil
+	storagePrefixes = nil
+	forceHTTPS, forceHTTPSHosts = false, nil
+	spaceEncoding, normalizeOnly, postCheckParams = "", false, false
+	bulkS3Prefix, bulkNoFilenameClean, keepEmpty = "https://dev-genesis.s3.ap-southeast-1.amazonaws.com/", false, true
+}
+
+func TestRemoveTagParamsFromURLDelimiters(t *testing.T) {
+	withCleaningConfig(t)
+	tests := []struct {
+		name, in, want string
+		changed        bool
+	}{
+		{"encoded & inside tag value", "https://h/p?tag=a%26b&real=1", "https://h/p?real=1", true},
+		{"encoded & inside kept value", "https://h/p?real=a%26b&tag=x", "https://h/p?real=a%26b", true},
+		{"invalid escape in other param kept verbatim", "https://h/p?x=%zz&tag=1&y=2", "https://h/p?x=%zz&y=2", true},
+		{"invalid escape in tag value", "https://h/p?tag=%zz&y=2", "https://h/p?y=2", true},
+		{"semicolon pair holding a tag left alone", "https://h/p?a=1;tag=2&b=3", "https://h/p?a=1;tag=2&b=3", false},
+		{"tag pair with semicolon left alone", "https://h/p?tag=1;a=2", "https://h/p?tag=1;a=2", false},
+		{"semicolon pair kept, tag removed", "https://h/p?a=1;b=2&tag=3", "https://h/p?a=1;b=2", true},
+		{"plus in kept value", "https://h/p?a=b+c&tag=1", "https://h/p?a=b+c", true},
+		{"fragment kept", "https://h/p?tag=1#frag", "https://h/p#frag", true},
+		{"only tags", "https://h/p?tag=1&tagging=2", "https://h/p", true},
+		{"no tag", "https://h/p?a=1", "https://h/p?a=1", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, changed := removeTagParamsFromURL(tc.in, nil)
+			if got != tc.want || changed != tc.changed {
+				t.Errorf("removeTagParamsFromURL(%q) = %q, %v; want %q, %v", tc.in, got, changed, tc.want, tc.changed)
+			}
+		})
+	}
+}
+
+func TestProtocolRelativeURLs(t *testing.T) {
+	withCleaningConfig(t)
+	bulkS3Prefix = "https://new-bucket.s3.amazonaws.com/"
+
+	tests := []struct {
+		name, in, cleaned, normalized string
+		changed                       bool
+	}{
+		{"tag removed, leading // kept", "//cdn.example.com/c.pdf?tag=x&v=2", "//cdn.example.com/c.pdf?v=2", "https://new-bucket.s3.amazonaws.com/c.pdf", true},
+		{"only tag", "//old-bucket.s3.amazonaws.com/dir/a.xlsx?tag=1", "//old-bucket.s3.amazonaws.com/dir/a.xlsx", "https://new-bucket.s3.amazonaws.com/a.xlsx", true},
+		{"no tag", "//cdn.example.com/c.pdf?v=2", "//cdn.example.com/c.pdf?v=2", "https://new-bucket.s3.amazonaws.com/c.pdf", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cleaned, changed := cleanURL(tc.in)
+			if cleaned != tc.cleaned || changed != tc.changed {
+				t.Errorf("cleanURL(%q) = %q, %v; want %q, %v", tc.in, cleaned, changed, tc.cleaned, tc.changed)
+			}
+			normalized, ok := normalizeBulkArchiveURL(cleaned)
+			if normalized != tc.normalized || !ok {
+				t.Errorf("normalizeBulkArchiveURL(%q) = %q, %v; want %q, true", cleaned, normalized, ok, tc.normalized)
+			}
+		})
+	}
+
+	// Client values match hydra prefixes literally: the //host form only if it is listed.
+	defer func(p []string) { hydraSignPrefixes = p }(hydraSignPrefixes)
+	hydraSignPrefixes = []string{"https://api.example.com/hydra/v1/asset/sign?"}
+	if hasHydraPrefix("//api.example.com/hydra/v1/asset/sign?key=a&tag=1") {
+		t.Error("protocol-relative URL matched an absolute hydra prefix")
+	}
+	hydraSignPrefixes = append(hydraSignPrefixes, "//api.example.com/hydra/v1/asset/sign?")
+	if !hasHydraPrefix("//api.example.com/hydra/v1/asset/sign?key=a&tag=1") {
+		t.Error("protocol-relative URL did not match its listed //host prefix")
+	}
+
+	// A protocol-relative host root has no filename to keep.
+	if got, ok := normalizeBulkArchiveURL("//old-bucket.s3.amazonaws.com/"); ok {
+		t.Errorf("normalizeBulkArchiveURL(host root) = %q, true; want ok false", got)
+	}
+}
+
+func TestBulkKeepEmpty(t *testing.T) {
+	withCleaningConfig(t)
+	bulkNoFilenameClean = true
+
+	process := func(archive sql.NullString) (bool, *migrationStats) {
+		t.Helper()
+		stats := newMigrationStats()
+		_, skipped, err := processBulkRowRemoveTag(t.Context(), nil, BulkRow{ID: 1, ArchiveFile: archive}, stats, true)
+		if err != nil {
+			t.Fatalf("processBulkRowRemoveTag(%q): %v", archive.String, err)
+		}
+		return skipped, stats
+	}
+
+	for _, keep := range []bool{true, false} {
+		keepEmpty = keep
+		for _, v := range []sql.NullString{{}, {Valid: true}, {String: "   ", Valid: true}, {String: "\t\n", Valid: true}} {
+			if skipped, stats := process(v); !skipped || stats.urlsCleaned != 0 {
+				t.Errorf("KEEP_EMPTY=%v: blank archive_file %q was not kept unchanged", keep, v.String)
+			}
+		}
+	}
+
+	bare := sql.NullString{String: bulkS3Prefix + "?tag=x", Valid: true}
+	keepEmpty = true
+	if skipped, stats := process(bare); !skipped || stats.skipReasons[skipBarePrefix] != 1 {
+		t.Errorf("KEEP_EMPTY on: %q skipped=%v reasons=%v, want skip %s", bare.String, skipped, stats.skipReasons, skipBarePrefix)
+	}
+	keepEmpty = false
+	if skipped, stats := process(bare); skipped || stats.urlsCleaned != 1 {
+		t.Errorf("KEEP_EMPTY=0: %q skipped=%v, want it cleaned", bare.String, skipped)
+	}
+}
+
+func TestCleanURLArray(t *testing.T) {
+	withCleaningConfig(t)
+	tests := []struct {
+		name, in, want   string
+		changed, isArray bool
+	}{
+		{"mixed clean and tagged", `["https://h/a.pdf?tag=1","https://h/b.pdf","https://h/c.pdf?v=2&tagging=3"]`,
+			`["https://h/a.pdf","https://h/b.pdf","https://h/c.pdf?v=2"]`, true, true},
+		{"all clean kept byte for byte", `[ "https://h/a.pdf" , "https://h/b.pdf?v=1" ]`,
+			`[ "https://h/a.pdf" , "https://h/b.pdf?v=1" ]`, false, true},
+		{"non-string elements kept", `["https://h/a.pdf?tag=1",null,42,1.50,true,{"u":"https://h/x?tag=1"},["https://h/y?tag=1"]]`,
+			`["https://h/a.pdf",null,42,1.50,true,{"u":"https://h/x?tag=1"},["https://h/y?tag=1"]]`, true, true},
+		{"ampersand not escaped", `["https://h/a.pdf?a=1&tag=2&b=3"]`, `["https://h/a.pdf?a=1&b=3"]`, true, true},
+		{"empty array", `[]`, `[]`, false, true},
+		{"invalid JSON is a single value", `["https://h/a.pdf?tag=1"`, `["https://h/a.pdf?tag=1"`, false, false},
+		{"trailing data is a single value", `["https://h/a.pdf?tag=1"] x`, `["https://h/a.pdf?tag=1"] x`, false, false},
+		{"plain URL", `https://h/a.pdf?tag=1`, `https://h/a.pdf?tag=1`, false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, changed, isArray := cleanURLArray(tc.in, cleanURL)
+			if got != tc.want || changed != tc.changed || isArray != tc.isArray {
+				t.Errorf("cleanURLArray(%s) = %s, %v, %v; want %s, %v, %v", tc.in, got, changed, isArray, tc.want, tc.changed, tc.isArray)
+			}
+		})
+	}
+}
+
+func TestForceHTTPSForHost(t *testing.T) {
+	withCleaningConfig(t)
+	forceHTTPS = true
+	forceHTTPSHosts = map[string]bool{"cdn.example.com": true}
+
+	tests := []struct {
+		name, in, want string
+		ok             bool
+	}{
+		{"listed host", "http://cdn.example.com/a.pdf?v=1", "https://cdn.example.com/a.pdf?v=1", true},
+		{"listed host, upper-case scheme and host", "HTTP://CDN.Example.COM/a.pdf", "https://CDN.Example.COM/a.pdf", true},
+		{"listed host with port", "http://cdn.example.com:8080/a.pdf", "https://cdn.example.com:8080/a.pdf", true},
+		{"rest kept byte for byte", "http://cdn.example.com/a%20b.pdf?x=%zz", "https://cdn.example.com/a%20b.pdf?x=%zz", true},
+		{"non-listed host", "http://other.example.com/a.pdf", "http://other.example.com/a.pdf", false},
+		{"listed host as suffix only", "http://evil-cdn.example.com/a.pdf", "http://evil-cdn.example.com/a.pdf", false},
+		{"subdomain of listed host", "http://x.cdn.example.com/a.pdf", "http://x.cdn.example.com/a.pdf", false},
+		{"already https", "https://cdn.example.com/a.pdf", "https://cdn.example.com/a.pdf", false},
+		{"protocol-relative", "//cdn.example.com/a.pdf", "//cdn.example.com/a.pdf", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := forceHTTPSForHost(tc.in)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("forceHTTPSForHost(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+
+	forceHTTPS = false
+	if got, ok := forceHTTPSForHost("http://cdn.example.com/a.pdf"); ok {
+		t.Errorf("FORCE_HTTPS off: forceHTTPSForHost = %q, true; want unchanged", got)
+	}
+}
+
+func TestCutStoragePrefix(t *testing.T) {
+	withCleaningConfig(t)
+	storagePrefixes = []string{"url:", "asset:", "asset://", "s3:/"}
+
+	tests := []struct {
+		name, in, prefix, inner string
+		ok                      bool
+	}{
+		{"plain URL", "https://h/a.pdf?tag=1", "", "https://h/a.pdf?tag=1", false},
+		{"url: prefix", "url:https://h/a.pdf?tag=1", "url:", "https://h/a.pdf?tag=1", true},
+		{"longest prefix wins", "asset://h/a.pdf?tag=1", "asset://", "h/a.pdf?tag=1", true},
+		{"shorter prefix when the longer does not match", "asset:https://h/a.pdf", "asset:", "https://h/a.pdf", true},
+		{"exact match leaves an empty inner value", "url:", "url:", "", true},
+		{"prefix cut inside a path segment", "s3://bucket/a.pdf?tag=1", "s3:/", "/bucket/a.pdf?tag=1", true},
+		{"prefix not at the start", "https://h/url:a.pdf", "", "https://h/url:a.pdf", false},
+		{"case-sensitive", "URL:https://h/a.pdf", "", "URL:https://h/a.pdf", false},
+		{"value shorter than the prefix", "url", "", "url", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prefix, inner, ok := cutStoragePrefix(tc.in)
+			if prefix != tc.prefix || inner != tc.inner || ok != tc.ok {
+				t.Errorf("cutStoragePrefix(%q) = %q, %q, %v; want %q, %q, %v", tc.in, prefix, inner, ok, tc.prefix, tc.inner, tc.ok)
+			}
+		})
+	}
+
+	// Cleaning puts the matched prefix back verbatim, whatever its boundary.
+	for in, want := range map[string]string{
+		"url:https://h/a.pdf?tag=1&v=2": "url:https://h/a.pdf?v=2",
+		"asset://h/a.pdf?tag=1":         "asset://h/a.pdf",
+		"s3://bucket/a.pdf?tag=1":       "s3://bucket/a.pdf",
+		"url:":                          "url:",
+		"https://h/a.pdf?tag=1":         "https://h/a.pdf",
+	} {
+		if got, _ := cleanURL(in); got != want {
+			t.Errorf("cleanURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEncodeSpaces(t *testing.T) {
+	withCleaningConfig(t)
+	tests := []struct {
+		name, encoding, encoded, original, want string
+	}{
+		{"plus", "plus", "a=b+c", "a=b%20c", "a=b+c"},
+		{"percent", "percent", "a=b+c&d=1%2B2", "a=b+c&d=1%2B2", "a=b%20c&d=1%2B2"},
+		{"default keeps %20", "", "a=b+c", "a=b%20c&tag=1", "a=b%20c"},
+		{"default keeps +", "", "a=b+c", "a=b+c&tag=1", "a=b+c"},
+		{"default with both spellings uses +", "", "a=b+c&d=e+f", "a=b%20c&d=e+f", "a=b+c&d=e+f"},
+		{"default without spaces", "", "a=1%2B2", "a=1%2B2&tag=1", "a=1%2B2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spaceEncoding = tc.encoding
+			if got := encodeSpaces(tc.encoded, tc.original); got != tc.want {
+				t.Errorf("encodeSpaces(%q, %q) = %q, want %q", tc.encoded, tc.original, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpaceEncodingCleanURL(t *testing.T) {
+	withCleaningConfig(t)
+	tests := []struct {
+		encoding, in, want string
+	}{
+		{"plus", "https://h/p?a=b%20c&tag=1", "https://h/p?a=b+c"},
+		{"percent", "https://h/p?a=b+c&tag=1", "https://h/p?a=b%20c"},
+		{"percent", "https://h/p?a=1%2B2&b=c+d&tag=1", "https://h/p?a=1%2B2&b=c%20d"},
+		{"", "https://h/p?a=b%20c&tag=1", "https://h/p?a=b%20c"},
+		{"", "https://h/p?a=b+c&tag=1", "https://h/p?a=b+c"},
+	}
+	for _, tc := range tests {
+		spaceEncoding = tc.encoding
+		if got, _ := cleanURL(tc.in); got != tc.want {
+			t.Errorf("SPACE_ENCODING=%q: cleanURL(%q) = %q, want %q", tc.encoding, tc.in, got, tc.want)
+		}
+	}
+}
+
+// captureReport sends the REPORT_OUT records to memory and returns a func decoding them.
+func captureReport(t *testing.T) func() []changeRecord {
+	t.Helper()
+	saved := reportEncoder
+	t.Cleanup(func() { reportEncoder = saved })
+	var buf bytes.Buffer
+	reportEncoder = json.NewEncoder(&buf)
+	return func() []changeRecord {
+		t.Helper()
+		var recs []changeRecord
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for dec.More() {
+			var rec changeRecord
+			if err := dec.Decode(&rec); err != nil {
+				t.Fatal(err)
+			}
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+}
+
+func TestBulkNoFilename(t *testing.T) {
+	withCleaningConfig(t)
+
+	for in, want := range map[string]string{
+		"https://host/dir/file.xlsx/?tag=x": bulkS3Prefix + "file.xlsx",
+		"https://host/?tag=x":               "",
+		"https://host?tag=x":                "",
+		"https://host//":                    "",
+	} {
+		got, ok := normalizeBulkArchiveURL(in)
+		if want == "" {
+			if ok || got != in {
+				t.Errorf("normalizeBulkArchiveURL(%q) = %q, %v; want unchanged, false", in, got, ok)
+			}
+		} else if !ok || got != want {
+			t.Errorf("normalizeBulkArchiveURL(%q) = %q, %v; want %q, true", in, got, ok, want)
+		}
+	}
+
+	rows := map[int64]string{1: "https://host/?tag=x", 2: "https://host?tag=x&v=1"}
+	for _, clean := range []bool{false, true} {
+		bulkNoFilenameClean = clean
+		records := captureReport(t)
+		stats := newMigrationStats()
+		for id, u := range rows {
+			row := BulkRow{ID: id, ArchiveFile: sql.NullString{String: u, Valid: true}}
+			if _, skipped, err := processBulkRowRemoveTag(t.Context(), nil, row, stats, true); err != nil || skipped != !clean {
+				t.Errorf("BULK_NO_FILENAME clean=%v: id=%d skipped=%v err=%v", clean, id, skipped, err)
+			}
+		}
+		if !clean {
+			if n := stats.skipReasons["no-filename"]; n != 2 {
+				t.Errorf("skipReasons[no-filename] = %d, want 2", n)
+			}
+			continue
+		}
+		got := make(map[string]string)
+		for _, rec := range records() {
+			got[rec.PK.String()] = rec.Columns[0].New
+		}
+		if want := map[string]string{"1": "https://host/", "2": "https://host?v=1"}; !maps.Equal(got, want) {
+			t.Errorf("BULK_NO_FILENAME=clean wrote %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNormalizeOnlyModes(t *testing.T) {
+	withCleaningConfig(t)
+	prefix := bulkS3Prefix
+	inputs := []struct{ kind, in string }{
+		{"tag-only", prefix + "a.xlsx?tag=1"},
+		{"normalize-only", "https://old-bucket.s3.amazonaws.com/dir/b.xlsx"},
+		{"combined", "https://old-bucket.s3.amazonaws.com/dir/c.xlsx?tag=1&v=2"},
+	}
+	// want is the written value per input kind; "" means the row is skipped as already-clean.
+	modes := []struct {
+		name          string
+		normalizeOnly bool
+		want          map[string]string
+	}{
+		{"default", false, map[string]string{
+			"tag-only":       prefix + "a.xlsx",
+			"normalize-only": "",
+			"combined":       prefix + "c.xlsx",
+		}},
+		{"NORMALIZE_ONLY", true, map[string]string{
+			"tag-only":       "",
+			"normalize-only": prefix + "b.xlsx",
+			"combined":       prefix + "c.xlsx?tag=1&v=2",
+		}},
+	}
+	for _, m := range modes {
+		normalizeOnly = m.normalizeOnly
+		for i, in := range inputs {
+			records := captureReport(t)
+			stats := newMigrationStats()
+			row := BulkRow{ID: int64(i + 1), ArchiveFile: sql.NullString{String: in.in, Valid: true}}
+			_, skipped, err := processBulkRowRemoveTag(t.Context(), nil, row, stats, true)
+			if err != nil {
+				t.Fatalf("%s %s: %v", m.name, in.kind, err)
+			}
+			want := m.want[in.kind]
+			if want == "" {
+				if !skipped || stats.skipReasons[skipAlreadyClean] != 1 {
+					t.Errorf("%s %s: skipped=%v reasons=%v, want already-clean", m.name, in.kind, skipped, stats.skipReasons)
+				}
+				continue
+			}
+			recs := records()
+			if skipped || len(recs) != 1 || recs[0].Columns[0].New != want {
+				t.Errorf("%s %s: skipped=%v records=%+v, want %q written", m.name, in.kind, skipped, recs, want)
+			}
+		}
+	}
+
+	// Plain URLs: NORMALIZE_ONLY keeps the tag but still applies the host rewrite.
+	forceHTTPS, forceHTTPSHosts = true, map[string]bool{"cdn.example.com": true}
+	for _, tc := range []struct {
+		normalizeOnly bool
+		in, want      string
+		changed       bool
+	}{
+		{false, "https://cdn.example.com/a.pdf?tag=1", "https://cdn.example.com/a.pdf", true},
+		{true, "https://cdn.example.com/a.pdf?tag=1", "https://cdn.example.com/a.pdf?tag=1", false},
+		{false, "http://cdn.example.com/a.pdf", "https://cdn.example.com/a.pdf", true},
+		{true, "http://cdn.example.com/a.pdf", "https://cdn.example.com/a.pdf", true},
+		{false, "http://cdn.example.com/a.pdf?tag=1", "https://cdn.example.com/a.pdf", true},
+		{true, "http://cdn.example.com/a.pdf?tag=1", "https://cdn.example.com/a.pdf?tag=1", true},
+	} {
+		normalizeOnly = tc.normalizeOnly
+		if got, changed := cleanURL(tc.in); got != tc.want || changed != tc.changed {
+			t.Errorf("NORMALIZE_ONLY=%v: cleanURL(%q) = %q, %v; want %q, %v", tc.normalizeOnly, tc.in, got, changed, tc.want, tc.changed)
+		}
+	}
+}
+
+func TestClientEligibleUnchangedSkipsPKList(t *testing.T) {
+	withCleaningConfig(t)
+	defer func(p []string) { hydraSignPrefixes = p }(hydraSignPrefixes)
+	hydraSignPrefixes = []string{"https://api.example.com/hydra/v1/asset/sign?"}
+	defer func(enc *json.Encoder) { eligibleUnchangedEncoder = enc }(eligibleUnchangedEncoder)
+	defer func(l map[string][]int64) { idsLists = l }(idsLists)
+
+	row := ClientRow{ClientID: 5, ClientContractAttachment: sql.NullString{String: "https://api.example.com/hydra/v1/asset/sign?key=a.pdf", Valid: true}}
+	for _, tc := range []struct {
+		name  string
+		ids   map[string][]int64
+		wants int
+	}{
+		{"LIKE prefilter", nil, 1},
+		{"pk list", map[string][]int64{"client": {5}}, 0},
+	} {
+		var buf bytes.Buffer
+		eligibleUnchangedEncoder = json.NewEncoder(&buf)
+		idsLists = tc.ids
+		if _, skipped, err := processClientRowRemoveTag(t.Context(), nil, row, newMigrationStats(), true); err != nil || !skipped {
+			t.Fatalf("%s: skipped=%v err=%v, want skipped", tc.name, skipped, err)
+		}
+		if n := strings.Count(buf.String(), "\n"); n != tc.wants {
+			t.Errorf("%s: %d eligible-unchanged records, want %d", tc.name, n, tc.wants)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Migration marker columns (MARK_COLUMN)
+// ------------------------------
+
+// markColumns maps table -> timestamp column set on every cleaned row, configured via
+// MARK_COLUMN="bulk.tag_cleaned_at,client.tag_cleaned_at". Fetches skip rows where it is
+// already set, so reruns only scan rows that have not been cleaned yet.
+var markColumns map[string]string
+
+// parseMarkColumns parses the MARK_COLUMN spec into table -> column.
+func parseMarkColumns(spec string) (map[string]string, error) {
+	cols := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		table, col, ok := strings.Cut(entry, ".")
+		if !ok || !isSQLIdentifier(table) || !isSQLIdentifier(col) {
+			return nil, &ConfigError{Key: "MARK_COLUMN", Err: fmt.Errorf("entry %q must be table.column", entry)}
+		}
+		cols[table] = col
+	}
+	return cols, nil
+}
+
+// validateMarkColumns checks at startup that every configured marker column exists.
+func validateMarkColumns(ctx context.Context, db *sqlx.DB) error {
+	for table, col := range markColumns {
+		cols, err := sqlDialect.tableColumns(ctx, db, table)
+		if err != nil {
+			return &DBError{Op: "inspect " + table, Err: err}
+		}
+		if !cols[col] {
+			return &ConfigError{Key: "MARK_COLUMN", Err: fmt.Errorf("column %s.%s does not exist", table, col)}
+		}
+	}
+	return nil
+}
+
+// markFilterSQL is the fetch predicate that skips rows already marked for table.
+func markFilterSQL(table string) string {
+	col, ok := markColumns[table]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("\n    AND %s IS NULL", col)
+}
+
+// markSetSQL is the extra SET clause stamping the marker column for table.
+func markSetSQL(table string) string {
+	col, ok := markColumns[table]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(", %s = %s", col, sqlDialect.now())
+}
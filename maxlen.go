@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Column length guard
+// ------------------------------
+
+// skipWouldTruncate is the skip reason for a row whose new value does not fit its column.
+const skipWouldTruncate = "would-truncate"
+
+// columnMaxLengths maps table -> column -> CHARACTER_MAXIMUM_LENGTH for every length-limited
+// column of the migrated tables. Unlimited columns (TEXT, JSON) are absent.
+var columnMaxLengths map[string]map[string]int
+
+// loadColumnMaxLengths reads the length limits of every migrated table. Must run after
+// adaptToSchema so that only existing EXTRA_TABLES are inspected.
+func loadColumnMaxLengths(ctx context.Context, db *sqlx.DB) error {
+	columnMaxLengths = make(map[string]map[string]int)
+	for _, table := range allTables() {
+		lengths, err := sqlDialect.columnMaxLengths(ctx, db, table)
+		if err != nil {
+			return &DBError{Op: "inspect " + table + " column lengths", Err: err}
+		}
+		columnMaxLengths[table] = lengths
+	}
+	return nil
+}
+
+// wouldTruncate reports whether rec would write a value longer than its column allows, logging
+// the offending column and counting the row as skipped. Tag removal only shortens URLs, but a
+// storage prefix rewrite (e.g. BULK_S3_PREFIX) can lengthen them, and MySQL would then truncate
+// or fail mid-run.
+func wouldTruncate(label string, rec changeRecord, stats *migrationStats) bool {
+	for _, c := range rec.Columns {
+		max, limited := columnMaxLengths[rec.Table][c.Name]
+		if !limited {
+			continue
+		}
+		if n := utf8.RuneCountInString(c.New); n > max {
+			log.Printf("[%s][SKIP] %s=%s reason=%s column=%s length=%d max=%d new=%s",
+				label, rec.PKColumn, rec.PK, skipWouldTruncate, c.Name, n, max, c.New)
+			stats.skip(skipWouldTruncate)
+			quarantineRow(rec.Table, rec.PKColumn, rec.PK, rec.DryRun, skipWouldTruncate,
+				fmt.Sprintf("column=%s length=%d max=%d new=%s", c.Name, n, max, c.New), map[string]string{c.Name: c.Old})
+			return true
+		}
+	}
+	return false
+}
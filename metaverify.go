@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ------------------------------
+// Partner meta self-check (PARTNER_META_VERIFY)
+// ------------------------------
+
+// partnerMetaVerify re-parses every rewritten partner meta before it is written and compares its
+// shape with the original (on by default, PARTNER_META_VERIFY=0 turns it off). A bug in the
+// rewrite must never corrupt meta, so a failing row is skipped rather than written.
+var partnerMetaVerify = true
+
+// errCorruptPartnerMeta marks a rewritten partner meta that failed the self-check.
+var errCorruptPartnerMeta = errors.New("rewritten partner meta failed the self-check")
+
+// checkPartnerMeta verifies that newMeta is still a JSON object with the same top-level keys and
+// value types as oldMeta, and that partner_pos_attach_files is still an array of the same length
+// whose elements kept their types. Only the file URL strings may differ.
+func checkPartnerMeta(oldMeta, newMeta string) error {
+	var before, after map[string]interface{}
+	if err := json.Unmarshal([]byte(oldMeta), &before); err != nil {
+		return fmt.Errorf("%w: original: %v", errCorruptPartnerMeta, err)
+	}
+	if err := json.Unmarshal([]byte(newMeta), &after); err != nil {
+		return fmt.Errorf("%w: %v", errCorruptPartnerMeta, err)
+	}
+	if after == nil {
+		return fmt.Errorf("%w: not a JSON object", errCorruptPartnerMeta)
+	}
+	if len(after) != len(before) {
+		return fmt.Errorf("%w: %d top-level keys, was %d", errCorruptPartnerMeta, len(after), len(before))
+	}
+	for key, v := range before {
+		nv, ok := after[key]
+		if !ok {
+			return fmt.Errorf("%w: key %q is missing", errCorruptPartnerMeta, key)
+		}
+		if jsonType(nv) != jsonType(v) {
+			return fmt.Errorf("%w: key %q is %s, was %s", errCorruptPartnerMeta, key, jsonType(nv), jsonType(v))
+		}
+	}
+
+	oldFiles, _ := before["partner_pos_attach_files"].([]interface{})
+	newFiles, _ := after["partner_pos_attach_files"].([]interface{})
+	if len(newFiles) != len(oldFiles) {
+		return fmt.Errorf("%w: partner_pos_attach_files has %d items, was %d", errCorruptPartnerMeta, len(newFiles), len(oldFiles))
+	}
+	for i := range oldFiles {
+		if jsonType(newFiles[i]) != jsonType(oldFiles[i]) {
+			return fmt.Errorf("%w: partner_pos_attach_files[%d] is %s, was %s", errCorruptPartnerMeta, i, jsonType(newFiles[i]), jsonType(oldFiles[i]))
+		}
+	}
+	return nil
+}
+
+// jsonType names the JSON type of a value decoded by encoding/json.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCheckPartnerMeta(t *testing.T) {
+	old := `{"partner_name":"P","limit":10,"partner_pos_attach_files":["https://h/a.pdf?tag=1",{"k":1}]}`
+	tests := []struct {
+		name, newMeta string
+		ok            bool
+	}{
+		{"urls cleaned", `{"partner_name":"P","limit":10,"partner_pos_attach_files":["https://h/a.pdf",{"k":1}]}`, true},
+		{"not json", `{"partner_name":"P"`, false},
+		{"not an object", `["https://h/a.pdf"]`, false},
+		{"key dropped", `{"partner_name":"P","partner_pos_attach_files":["https://h/a.pdf",{"k":1}]}`, false},
+		{"key added", `{"partner_name":"P","limit":10,"x":1,"partner_pos_attach_files":["https://h/a.pdf",{"k":1}]}`, false},
+		{"type changed", `{"partner_name":"P","limit":"10","partner_pos_attach_files":["https://h/a.pdf",{"k":1}]}`, false},
+		{"file dropped", `{"partner_name":"P","limit":10,"partner_pos_attach_files":["https://h/a.pdf"]}`, false},
+		{"file element type changed", `{"partner_name":"P","limit":10,"partner_pos_attach_files":["https://h/a.pdf","k"]}`, false},
+		{"files no longer an array", `{"partner_name":"P","limit":10,"partner_pos_attach_files":"https://h/a.pdf"}`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPartnerMeta(old, tc.newMeta)
+			if tc.ok && err != nil {
+				t.Fatalf("checkPartnerMeta: %v", err)
+			}
+			if !tc.ok && !errors.Is(err, errCorruptPartnerMeta) {
+				t.Fatalf("checkPartnerMeta = %v, want errCorruptPartnerMeta", err)
+			}
+		})
+	}
+}
+
+// TestPartnerMetaVerifySkipsCorruptRewrite injects a re-serialization that drops a key and
+// checks that the row is skipped as meta-verify-failed instead of being written.
+func TestPartnerMetaVerifySkipsCorruptRewrite(t *testing.T) {
+	withCleaningConfig(t)
+	t.Cleanup(func() { marshalPartnerMeta = json.Marshal })
+	marshalPartnerMeta = func(v interface{}) ([]byte, error) {
+		m := v.(map[string]interface{})
+		delete(m, "partner_name")
+		return json.Marshal(m)
+	}
+
+	row := PartnerRow{
+		PartnerID: 7,
+		Meta:      sql.NullString{String: `{"partner_name":"P","partner_pos_attach_files":["https://h/a.pdf?tag=1"]}`, Valid: true},
+	}
+	for _, dryRun := range []bool{true, false} {
+		stats := newMigrationStats()
+		// The row must be rejected before the DB is touched, so no DB is passed.
+		updated, skipped, err := processPartnerRowRemoveTag(t.Context(), nil, row, stats, dryRun)
+		if err != nil || updated || !skipped {
+			t.Fatalf("dryRun=%v: got updated=%v skipped=%v err=%v, want skipped", dryRun, updated, skipped, err)
+		}
+		if stats.skipReasons[skipMetaVerifyFailed] != 1 || stats.urlsCleaned != 0 {
+			t.Fatalf("dryRun=%v: skipReasons=%v urlsCleaned=%d", dryRun, stats.skipReasons, stats.urlsCleaned)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Incremental runs (MODIFIED_SINCE)
+// ------------------------------
+
+// modifiedColumn is the last-modified column MODIFIED_SINCE filters on.
+const modifiedColumn = "updated_at"
+
+// modifiedSince (MODIFIED_SINCE) restricts the fetch queries to rows with updated_at at or after
+// this timestamp ("" = no restriction), for periodic sweeps over recently changed rows only.
+// It is compared in the database's own time zone. modifiedTables holds the tables that have the
+// column; the others are scanned in full.
+var (
+	modifiedSince  string
+	modifiedTables map[string]bool
+)
+
+// modifiedSinceLayouts are the accepted MODIFIED_SINCE formats.
+var modifiedSinceLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// parseModifiedSince normalizes a MODIFIED_SINCE value to "YYYY-MM-DD HH:MM:SS".
+func parseModifiedSince(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	for _, layout := range modifiedSinceLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Format("2006-01-02 15:04:05"), nil
+		}
+	}
+	return "", &ConfigError{Key: "MODIFIED_SINCE", Err: fmt.Errorf("%q is not a timestamp (use YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)", v)}
+}
+
+// detectModifiedColumns finds which selected tables have updated_at.
+func detectModifiedColumns(ctx context.Context, db *sqlx.DB) error {
+	if modifiedSince == "" {
+		return nil
+	}
+	modifiedTables = make(map[string]bool)
+	for _, table := range allTables() {
+		if !runsTable(table) {
+			continue
+		}
+		cols, err := sqlDialect.tableColumns(ctx, db, table)
+		if err != nil {
+			return &DBError{Op: "inspect " + table, Err: err}
+		}
+		if cols[modifiedColumn] {
+			modifiedTables[table] = true
+			log.Printf("MODIFIED_SINCE=%s: %s only rows with %s >= %s", modifiedSince, table, modifiedColumn, modifiedSince)
+		} else {
+			log.Printf("[WARN] MODIFIED_SINCE: %s has no %s column, scanning all its rows", table, modifiedColumn)
+		}
+	}
+	return nil
+}
+
+// modifiedSinceSQL is the MODIFIED_SINCE condition for table's fetch query and its argument,
+// or nothing if there is no restriction.
+func modifiedSinceSQL(table string) (string, []interface{}) {
+	if modifiedSince == "" || !modifiedTables[table] {
+		return "", nil
+	}
+	return fmt.Sprintf("\n    AND %s >= ?", modifiedColumn), []interface{}{modifiedSince}
+}
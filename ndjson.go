@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
+// ------------------------------
+// Dry-run NDJSON output (DRYRUN_NDJSON)
+// ------------------------------
+
+// dryRunNDJSON (DRYRUN_NDJSON=1) writes each would-change row of a dry run to stdout as one
+// compact change record per line, instead of the multi-line old=/new= log. Logs and summaries
+// stay on stderr, so stdout can be piped straight into jq.
+var dryRunNDJSON bool
+
+var (
+	dryRunEncoder   = json.NewEncoder(os.Stdout)
+	dryRunEncoderMu sync.Mutex
+)
+
+// emitDryRunNDJSON writes rec to stdout if DRYRUN_NDJSON is on and reports whether it did, in
+// which case the caller skips its human-readable log line.
+func emitDryRunNDJSON(rec changeRecord) bool {
+	if !dryRunNDJSON {
+		return false
+	}
+	rec.sortColumns()
+	dryRunEncoderMu.Lock()
+	defer dryRunEncoderMu.Unlock()
+	if err := dryRunEncoder.Encode(rec); err != nil {
+		log.Printf("[WARN] failed to write dry-run record for %s %s=%s: %v", rec.Table, rec.PKColumn, rec.PK, err)
+	}
+	return true
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ------------------------------
+// Migration order (MIGRATE_ORDER)
+// ------------------------------
+
+// migrationOrder is the sequence migrations run in. MIGRATE_ORDER="client,bulk" puts the listed
+// migrations first, in that order; the others follow in the default order (see allTables).
+var migrationOrder []string
+
+// parallelTables (PARALLEL_TABLES=1) runs the selected migrations concurrently instead of one
+// after the other. They share the connection pool, the MAX_WRITES budget and the output files.
+var parallelTables bool
+
+// parseMigrateOrder validates the MIGRATE_ORDER names against the known tables.
+func parseMigrateOrder(spec string) ([]string, error) {
+	known := make(map[string]bool)
+	for _, t := range migrationNames() {
+		known[t] = true
+	}
+
+	var order []string
+	listed := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, &ConfigError{Key: "MIGRATE_ORDER", Err: fmt.Errorf("unknown migration %q (known: %s)", name, strings.Join(migrationNames(), ", "))}
+		}
+		if listed[name] {
+			return nil, &ConfigError{Key: "MIGRATE_ORDER", Err: fmt.Errorf("migration %q listed twice", name)}
+		}
+		listed[name] = true
+		order = append(order, name)
+	}
+	for _, t := range migrationNames() {
+		if !listed[t] {
+			order = append(order, t)
+		}
+	}
+	return order, nil
+}
+
+// selectedTables (TABLES="client,documents") restricts the run to the listed migrations; nil runs
+// all of them. The order still comes from MIGRATE_ORDER.
+var selectedTables map[string]bool
+
+// parseTables validates the TABLES names against the known tables.
+func parseTables(spec string) (map[string]bool, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	for _, t := range migrationNames() {
+		known[t] = true
+	}
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, &ConfigError{Key: "TABLES", Err: fmt.Errorf("unknown migration %q (known: %s)", name, strings.Join(migrationNames(), ", "))}
+		}
+		selected[name] = true
+	}
+	if len(selected) == 0 {
+		return nil, &ConfigError{Key: "TABLES", Err: fmt.Errorf("no migration names in %q", spec)}
+	}
+	return selected, nil
+}
+
+// runMigrations runs every selected migration, one after the other in migrationOrder or all at
+// once with PARALLEL_TABLES. Hitting MAX_WRITES ends the run cleanly.
+func runMigrations(ctx context.Context, db *sqlx.DB, dryRun bool, batchSize int) error {
+	if parallelTables {
+		return runMigrationsParallel(ctx, db, dryRun, batchSize)
+	}
+	log.Printf("migration order: %s", strings.Join(migrationOrder, " -> "))
+	for _, name := range migrationOrder {
+		if !runsTable(name) {
+			continue
+		}
+		if err := runMigrationSharded(ctx, db, name, dryRun, batchSize); err != nil {
+			if errors.Is(err, errMaxWritesReached) {
+				return stopOnWriteLimit(err)
+			}
+			return fmt.Errorf("%s migration failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runMigration runs the migration for table name.
+func runMigration(ctx context.Context, db *sqlx.DB, name string, dryRun bool, batchSize int) error {
+	attrs := []attribute.KeyValue{attribute.String("migration", name)}
+	if r, ok := shardOf(ctx); ok {
+		attrs = append(attrs, attribute.Int("shard", r.shard), attribute.String("shard.id_range", fmt.Sprintf("%d..%d", r.lo+1, r.hi)))
+	}
+	ctx, span := tracer.Start(ctx, "migrate "+name, trace.WithAttributes(attrs...))
+	err := dispatchMigration(ctx, db, name, dryRun, batchSize)
+	endSpan(span, err)
+	return err
+}
+
+// dispatchMigration calls the migration function for name.
+func dispatchMigration(ctx context.Context, db *sqlx.DB, name string, dryRun bool, batchSize int) error {
+	switch name {
+	case "bulk":
+		return migrateBulkRemoveTag(ctx, db, dryRun, batchSize)
+	case "partner":
+		return migratePartnerRemoveTag(ctx, db, dryRun, batchSize)
+	case "client":
+		if len(clientAttachmentColumns) == 0 {
+			log.Println("== CLIENT: skipped, no attachment columns in this schema ==")
+			return nil
+		}
+		return migrateClientRemoveTag(ctx, db, dryRun, batchSize)
+	}
+	for _, t := range genericTables {
+		if t.Table == name {
+			return migrateGenericRemoveTag(ctx, db, t, dryRun, batchSize)
+		}
+	}
+	for _, t := range htmlColumns {
+		if t.name() == name {
+			return migrateGenericRemoveTag(ctx, db, t, dryRun, batchSize)
+		}
+	}
+	// EXTRA_TABLES entries dropped by adaptToSchema.
+	log.Printf("== %s: skipped, not available in this schema ==", strings.ToUpper(name))
+	return nil
+}
+
+// ------------------------------
+// Parallel migrations (PARALLEL_TABLES)
+// ------------------------------
+
+// migrationSummary is the end-of-run totals of one migration.
+type migrationSummary struct {
+	name                                string
+	rows, updated, skipped, urlsCleaned int
+}
+
+// errSiblingFailed cancels the remaining parallel migrations once one of them fails.
+var errSiblingFailed = errors.New("another parallel migration failed")
+
+var (
+	summaries   []migrationSummary
+	summariesMu sync.Mutex
+)
+
+// recordSummary keeps the totals of a finished migration for the combined PARALLEL_TABLES summary.
+// The shards of a SHARDS migration add up into one entry.
+func recordSummary(name string, rows, updated, skipped, urlsCleaned int) {
+	summariesMu.Lock()
+	defer summariesMu.Unlock()
+	for i := range summaries {
+		if s := &summaries[i]; s.name == name {
+			s.rows += rows
+			s.updated += updated
+			s.skipped += skipped
+			s.urlsCleaned += urlsCleaned
+			return
+		}
+	}
+	summaries = append(summaries, migrationSummary{name, rows, updated, skipped, urlsCleaned})
+}
+
+// summaryTotals adds up the recorded totals of all migrations.
+func summaryTotals() migrationSummary {
+	summariesMu.Lock()
+	defer summariesMu.Unlock()
+	var total migrationSummary
+	for _, s := range summaries {
+		total.rows += s.rows
+		total.updated += s.updated
+		total.skipped += s.skipped
+		total.urlsCleaned += s.urlsCleaned
+	}
+	return total
+}
+
+// summaryOf returns the recorded totals of migration name.
+func summaryOf(name string) (migrationSummary, bool) {
+	summariesMu.Lock()
+	defer summariesMu.Unlock()
+	for _, s := range summaries {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return migrationSummary{}, false
+}
+
+// runMigrationsParallel runs every selected migration in its own goroutine. The first failure
+// cancels the others (rows already started still finish); MAX_WRITES only stops the run cleanly,
+// like in sequential mode. The per-migration summaries are combined once all have returned.
+func runMigrationsParallel(ctx context.Context, db *sqlx.DB, dryRun bool, batchSize int) error {
+	var names []string
+	for _, name := range migrationOrder {
+		if runsTable(name) {
+			names = append(names, name)
+		}
+	}
+	log.Printf("running migrations in parallel: %s", strings.Join(names, ", "))
+	// Every migration holds at least one connection while it runs.
+	if stats := db.Stats(); stats.MaxOpenConnections > 0 && stats.MaxOpenConnections < len(names) {
+		db.SetMaxOpenConns(len(names))
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runMigrationSharded(runCtx, db, name, dryRun, batchSize)
+			if err != nil && !errors.Is(err, errMaxWritesReached) && ctx.Err() == nil {
+				cancel(errSiblingFailed)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	logSummaries()
+
+	var limitErr, failed error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			log.Printf("[PARALLEL] %s: done", names[i])
+		case errors.Is(err, errMaxWritesReached):
+			log.Printf("[PARALLEL] %s: %v", names[i], err)
+			if limitErr == nil {
+				limitErr = err
+			}
+		default:
+			log.Printf("[PARALLEL] %s: %v", names[i], err)
+			// Prefer the migration that failed on its own over the ones it cancelled.
+			if failed == nil || errors.Is(failed, errSiblingFailed) && !errors.Is(err, errSiblingFailed) {
+				failed = fmt.Errorf("%s migration failed: %w", names[i], err)
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	if limitErr != nil {
+		return stopOnWriteLimit(limitErr)
+	}
+	return nil
+}
+
+// logSummaries prints the combined totals of the migrations that reached their summary.
+func logSummaries() {
+	summariesMu.Lock()
+	defer summariesMu.Unlock()
+	var total migrationSummary
+	names := make([]string, 0, len(summaries))
+	for _, s := range summaries {
+		names = append(names, s.name)
+		total.rows += s.rows
+		total.updated += s.updated
+		total.skipped += s.skipped
+		total.urlsCleaned += s.urlsCleaned
+	}
+	log.Printf("[PARALLEL][SUMMARY] migrations=%s totalRows=%d totalUpdated=%d totalSkipped=%d urlsCleaned=%d",
+		strings.Join(names, ","), total.rows, total.updated, total.skipped, total.urlsCleaned)
+}
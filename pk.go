@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ------------------------------
+// Primary keys
+// ------------------------------
+
+// pkValue is the primary key of one row: an int64 (all built-in tables, and the default for
+// EXTRA_TABLES) or a string, for EXTRA_TABLES entries declared with a string pk (e.g. UUIDs).
+// It is written to the report as a JSON number or string respectively, and is usable as a
+// query argument either way.
+type pkValue struct {
+	n     int64
+	s     string
+	isStr bool
+}
+
+func intPK(n int64) pkValue { return pkValue{n: n} }
+
+func stringPK(s string) pkValue { return pkValue{s: s, isStr: true} }
+
+func (p pkValue) String() string {
+	if p.isStr {
+		return p.s
+	}
+	return strconv.FormatInt(p.n, 10)
+}
+
+// Value makes p a query argument.
+func (p pkValue) Value() (driver.Value, error) {
+	if p.isStr {
+		return p.s, nil
+	}
+	return p.n, nil
+}
+
+// sqlLiteral renders p for the rollback script.
+func (p pkValue) sqlLiteral() string {
+	if p.isStr {
+		return sqlDialect.quoteString(p.s)
+	}
+	return strconv.FormatInt(p.n, 10)
+}
+
+func (p pkValue) MarshalJSON() ([]byte, error) {
+	if p.isStr {
+		return json.Marshal(p.s)
+	}
+	return strconv.AppendInt(nil, p.n, 10), nil
+}
+
+func (p *pkValue) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*p = stringPK(s)
+		return nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid pk %s: %w", b, err)
+	}
+	*p = intPK(n)
+	return nil
+}
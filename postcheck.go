@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// ------------------------------
+// Post-check of query params (POST_CHECK_PARAMS)
+// ------------------------------
+
+// POST_CHECK_PARAMS=1 asserts, for every cleaned URL, that the new query params equal the old
+// ones minus exactly the tag params. A violating URL is never written; with POST_CHECK_ABORT=1
+// the whole run is also stopped.
+var (
+	postCheckParams bool
+	postCheckAbort  bool
+)
+
+// errPostCheckFailed is the cancel cause when POST_CHECK_ABORT stops the run.
+var errPostCheckFailed = errors.New("post-check: cleaning dropped or altered non-tag query params")
+
+// abortRun cancels the run context with a cause; set by run().
+var abortRun context.CancelCauseFunc = func(error) {}
+
+// checkParamsPreserved returns an error if newURL's query params are not oldURL's params minus
+// the tag params that shouldRemove(key, decoded value) removes (all of them if it is nil), so
+// tag pairs kept by TAG_VALUE_REGEX still have to be there.
+func checkParamsPreserved(oldURL, newURL string, shouldRemove func(key, value string) bool) error {
+	oldU, err := parseURL(oldURL)
+	if err != nil {
+		return err
+	}
+	newU, err := parseURL(newURL)
+	if err != nil {
+		return err
+	}
+
+	drop := make(map[string]bool, len(tagParams))
+	for _, k := range tagParams {
+		drop[k] = true
+	}
+
+	want := make(map[string]int)
+	for pair, n := range queryPairs(oldU.RawQuery) {
+		key, value, _ := strings.Cut(pair, "=")
+		if !drop[key] || (shouldRemove != nil && !shouldRemove(key, value)) {
+			want[pair] = n
+		}
+	}
+	got := queryPairs(newU.RawQuery)
+
+	for pair, n := range want {
+		if got[pair] != n {
+			return fmt.Errorf("param %q: want %d occurrence(s), got %d", pair, n, got[pair])
+		}
+	}
+	for pair, n := range got {
+		if want[pair] != n {
+			return fmt.Errorf("unexpected param %q (x%d)", pair, n)
+		}
+	}
+	return nil
+}
+
+// queryPairs returns the multiset of decoded key=value pairs of rawQuery. Pairs that cannot be
+// decoded are kept raw so they still have to survive cleaning unchanged.
+func queryPairs(rawQuery string) map[string]int {
+	pairs := make(map[string]int)
+	if rawQuery == "" {
+		return pairs
+	}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		k, errK := url.QueryUnescape(key)
+		v, errV := url.QueryUnescape(value)
+		if errK != nil || errV != nil {
+			pairs[pair]++
+			continue
+		}
+		pairs[k+"="+v]++
+	}
+	return pairs
+}
+
+// reportPostCheckViolation logs a violating URL as critical and, with POST_CHECK_ABORT=1, stops the run.
+func reportPostCheckViolation(oldURL, newURL string, err error) {
+	log.Printf("[CRITICAL] post-check failed, URL left unchanged: %v\nold=%s\nnew=%s", err, oldURL, newURL)
+	logErrorJSON("post_check_params", map[string]interface{}{
+		"old_url": oldURL,
+		"new_url": newURL,
+		"abort":   postCheckAbort,
+	}, err)
+	if postCheckAbort {
+		abortRun(fmt.Errorf("%w: %v", errPostCheckFailed, err))
+	}
+}
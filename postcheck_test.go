@@ -0,0 +1,45 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCheckParamsPreservedTagValueRegex(t *testing.T) {
+	defer func(p []string, re *regexp.Regexp) { tagParams, tagValueRegex = p, re }(tagParams, tagValueRegex)
+	tagParams = []string{"tag", "tagging"}
+	tagValueRegex = regexp.MustCompile(`^label$`)
+
+	const uuid = "0b6c6f7e-3d2a-4f59-9c0e-6a1b2c3d4e5f"
+	oldURL := "https://cdn.example.com/a.pdf?tag=" + uuid + "&tagging=label&v=1"
+
+	newURL, changed := removeTagParamsFromURL(oldURL, removeTagValue)
+	if want := "https://cdn.example.com/a.pdf?tag=" + uuid + "&v=1"; !changed || newURL != want {
+		t.Fatalf("removeTagParamsFromURL = %q, %v; want %q, true", newURL, changed, want)
+	}
+	if err := checkParamsPreserved(oldURL, newURL, removeTagValue); err != nil {
+		t.Errorf("checkParamsPreserved rejected a kept tag pair: %v", err)
+	}
+
+	// Dropping the kept tag pair, or keeping the removed one, is still a violation.
+	for _, bad := range []string{
+		"https://cdn.example.com/a.pdf?v=1",
+		"https://cdn.example.com/a.pdf?tag=" + uuid + "&tagging=label&v=1",
+	} {
+		if err := checkParamsPreserved(oldURL, bad, removeTagValue); err == nil {
+			t.Errorf("checkParamsPreserved(%q) = nil, want an error", bad)
+		}
+	}
+}
+
+func TestCleanURLPostCheckWithTagValueRegex(t *testing.T) {
+	defer func(p []string, re *regexp.Regexp, pc bool) { tagParams, tagValueRegex, postCheckParams = p, re, pc }(tagParams, tagValueRegex, postCheckParams)
+	tagParams = []string{"tag", "tagging"}
+	tagValueRegex = regexp.MustCompile(`^label$`)
+	postCheckParams = true
+
+	got, changed := cleanURL("https://cdn.example.com/a.pdf?tag=x1&tagging=label&v=1")
+	if want := "https://cdn.example.com/a.pdf?tag=x1&v=1"; !changed || got != want {
+		t.Errorf("cleanURL = %q, %v; want %q, true", got, changed, want)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// MODE=prefix-audit: SQL hydra prefilter vs Go prefix check
+// ------------------------------
+
+// prefixAuditMaxLogged caps the mismatching rows logged individually; all are counted.
+const prefixAuditMaxLogged = 50
+
+type prefixAuditRow struct {
+	ClientRow
+	SQLMatch int `db:"sql_match"`
+}
+
+// prefixAudit samples up to PREFIX_AUDIT_SAMPLE eligible client rows (ignoring the hydra LIKE
+// prefilter) and compares, per row, whether the SQL LIKE would select it with whether Go's
+// hasHydraPrefix would touch any of its URLs. Mismatches mean the two checks have drifted:
+// SQL-only rows are wasted scans, Go-only rows are never cleaned. Read-only.
+func prefixAudit(ctx context.Context, db *sqlx.DB, batchSize int) error {
+	if len(clientAttachmentColumns) == 0 {
+		return errors.New("prefix-audit: client has no attachment columns")
+	}
+	sample := loadNonNegativeIntFromEnv("PREFIX_AUDIT_SAMPLE", 10000)
+
+	likeSQL, likeArgs := clientHydraLikeSQL()
+	query := `
+SELECT
+    client_id,
+    ` + strings.Join(clientAttachmentColumns, ",\n    ") + `,
+    CASE WHEN ` + likeSQL + ` THEN 1 ELSE 0 END AS sql_match
+FROM client
+WHERE
+    client_id > ?
+    AND ` + clientEligibleSQL() + `
+ORDER BY client_id ASC
+LIMIT ?
+`
+	log.Printf("[PREFIX-AUDIT] sampling up to %d client rows against %d hydra prefix(es)", sample, len(hydraSignPrefixes))
+
+	var (
+		lastID                         int64
+		sampled, both, sqlOnly, goOnly int
+	)
+	for sampled < sample {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("prefix-audit interrupted after client_id=%d: %w", lastID, context.Cause(ctx))
+		}
+		limit := batchSize
+		if sample-sampled < limit {
+			limit = sample - sampled
+		}
+		args := make([]interface{}, 0, len(likeArgs)+2)
+		args = append(args, likeArgs...)
+		args = append(args, lastID, limit)
+
+		var rows []prefixAuditRow
+		if err := db.SelectContext(ctx, &rows, query, args...); err != nil {
+			return &DBError{Op: "select client", Err: err}
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, r := range rows {
+			sampled++
+			lastID = r.ClientID
+			sqlMatch := r.SQLMatch == 1
+			goMatch := clientRowHasHydraURL(r.ClientRow)
+			switch {
+			case sqlMatch && goMatch:
+				both++
+				continue
+			case sqlMatch:
+				sqlOnly++
+			case goMatch:
+				goOnly++
+			default:
+				continue
+			}
+			if sqlOnly+goOnly <= prefixAuditMaxLogged {
+				log.Printf("[PREFIX-AUDIT][MISMATCH] client_id=%d sql_like=%v go_prefix=%v contract=%q tax=%q pks=%q",
+					r.ClientID, sqlMatch, goMatch, r.ClientContractAttachment.String, r.ClientTaxAttachment.String, r.ClientPksAttachment.String)
+			}
+		}
+	}
+
+	log.Printf("[PREFIX-AUDIT][SUMMARY] sampled=%d bothMatch=%d sqlOnly=%d goOnly=%d", sampled, both, sqlOnly, goOnly)
+	if sqlOnly+goOnly > prefixAuditMaxLogged {
+		log.Printf("[PREFIX-AUDIT][SUMMARY] only the first %d mismatches were logged", prefixAuditMaxLogged)
+	}
+	if sqlOnly+goOnly == 0 {
+		log.Println("[PREFIX-AUDIT][SUMMARY] SQL prefilter and Go prefix check agree on the sample")
+	}
+	return nil
+}
+
+// clientRowHasHydraURL reports whether processClientRowRemoveTag would consider any URL of row,
+// i.e. whether one of its (array) values passes hasHydraPrefix.
+func clientRowHasHydraURL(row ClientRow) bool {
+	for _, v := range []string{row.ClientContractAttachment.String, row.ClientTaxAttachment.String, row.ClientPksAttachment.String} {
+		for _, u := range columnURLs(v) {
+			if hasHydraPrefix(u) {
+				return true
+			}
+		}
+	}
+	return false
+}
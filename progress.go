@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Batch progress table (PROGRESS_TABLE)
+// ------------------------------
+
+// progressTable (PROGRESS_TABLE) names a table that gets one row per committed batch of a real
+// run: run_id, table_name, batch_num, id_range, rows_updated, committed_at. It is a durable,
+// queryable progress trail for dashboards, created if missing.
+var progressTable string
+
+// ensureProgressTable creates PROGRESS_TABLE if it does not exist.
+func ensureProgressTable(ctx context.Context, db *sqlx.DB) error {
+	if progressTable == "" {
+		return nil
+	}
+	if err := sqlDialect.createProgressTable(ctx, db, progressTable); err != nil {
+		return &DBError{Op: "create " + progressTable, Err: err}
+	}
+	log.Printf("PROGRESS_TABLE: recording committed batches in %s", progressTable)
+	return nil
+}
+
+// recordProgress inserts the progress row of a committed batch. Dry runs write nothing. It is
+// best-effort: failures are logged, never returned, so the trail cannot stop a migration.
+func recordProgress(ctx context.Context, db *sqlx.DB, name string, batchNum int, first, last interface{}, rowsUpdated int, dryRun bool) {
+	if progressTable == "" || dryRun {
+		return
+	}
+	idRange := fmt.Sprintf("%v..%v", first, last)
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+INSERT INTO %s (run_id, table_name, batch_num, id_range, rows_updated, committed_at)
+VALUES (?, ?, ?, ?, ?, %s)
+`, progressTable, sqlDialect.now()), runID, name, batchNum, idRange, rowsUpdated)
+	if err != nil {
+		log.Printf("[WARN] failed to record progress of %s batch #%d in %s: %v", name, batchNum, progressTable, err)
+	}
+}
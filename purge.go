@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ------------------------------
+// Cache purge list (PURGE_LIST_OUT)
+// ------------------------------
+
+// purgeListPath (PURGE_LIST_OUT) is the file that gets, per table, the distinct old URLs a real
+// run changed, for the CDN/cache purge tooling. It is built from the change records only (no
+// extra queries) and overwritten at the end of every real run.
+var purgeListPath string
+
+// purgeURLs holds the changed old URLs by table. recordChange adds to it while holding recordMu.
+var purgeURLs = make(map[string]map[string]bool)
+
+// addPurgeURLs collects the old URLs of the applied change rec.
+func addPurgeURLs(rec changeRecord) {
+	if purgeListPath == "" || rec.DryRun {
+		return
+	}
+	for _, c := range rec.Columns {
+		for _, u := range changedURLs(c.Old, c.New) {
+			if purgeURLs[rec.Table] == nil {
+				purgeURLs[rec.Table] = make(map[string]bool)
+			}
+			purgeURLs[rec.Table][u] = true
+		}
+	}
+}
+
+// changedURLs returns the URLs of the column value old that are gone from new. A value is a
+// single URL, a JSON document (partner meta, URL arrays) whose strings are compared, or an
+// HTML document (HTML_COLUMNS) whose href/src links are compared. Storage prefixes are
+// stripped, since the caches key on the URL itself.
+func changedURLs(old, new string) []string {
+	kept := make(map[string]int)
+	for _, s := range valueURLs(new) {
+		kept[s]++
+	}
+	var changed []string
+	for _, s := range valueURLs(old) {
+		if kept[s] > 0 {
+			kept[s]--
+			continue
+		}
+		_, inner, _ := cutStoragePrefix(s)
+		changed = append(changed, inner)
+	}
+	return changed
+}
+
+// valueURLs lists the URL candidates of a column value (see changedURLs).
+func valueURLs(v string) []string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(v), &doc); err == nil {
+			var urls []string
+			collectJSONStrings(doc, &urls)
+			return urls
+		}
+	}
+	if strings.Contains(v, "<") {
+		return htmlLinks(v)
+	}
+	return []string{v}
+}
+
+// collectJSONStrings appends every string in doc to out.
+func collectJSONStrings(doc interface{}, out *[]string) {
+	switch d := doc.(type) {
+	case string:
+		*out = append(*out, d)
+	case []interface{}:
+		for _, item := range d {
+			collectJSONStrings(item, out)
+		}
+	case map[string]interface{}:
+		for _, item := range d {
+			collectJSONStrings(item, out)
+		}
+	}
+}
+
+// writePurgeList writes the PURGE_LIST_OUT file: a "# <table>" line per table followed by its
+// sorted URLs, one per line. It is best-effort: failures are logged, never returned.
+func writePurgeList(dryRun bool) {
+	if purgeListPath == "" || dryRun {
+		return
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	tables := make([]string, 0, len(purgeURLs))
+	for table := range purgeURLs {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var b strings.Builder
+	total := 0
+	for _, table := range tables {
+		urls := make([]string, 0, len(purgeURLs[table]))
+		for u := range purgeURLs[table] {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		fmt.Fprintf(&b, "# %s\n", table)
+		for _, u := range urls {
+			b.WriteString(u + "\n")
+		}
+		total += len(urls)
+	}
+	if err := os.WriteFile(purgeListPath, []byte(b.String()), 0o644); err != nil {
+		log.Printf("[WARN] failed to write purge list %s: %v", purgeListPath, err)
+		return
+	}
+	log.Printf("purge list written to %s (%d URLs in %d tables)", purgeListPath, total, len(tables))
+}
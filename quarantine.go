@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------------------------
+// Quarantine (QUARANTINE_OUT, MODE=review-quarantine)
+// ------------------------------
+
+// Skip reasons of anomalous rows, quarantined along with skipWouldTruncate.
+const (
+	skipUnparseableURL   = "unparseable-url"
+	skipInvalidMeta      = "invalid-meta"
+	skipMetaVerifyFailed = "meta-verify-failed"
+	skipUnexpectedHost   = "unexpected-host"
+	skipOversizedMeta    = "oversized-meta"
+)
+
+// expectedHosts (QUARANTINE_HOSTS) are the lower-cased hosts URLs are expected to point at. When
+// set, a row holding a URL on any other host is skipped as unexpected-host.
+var expectedHosts map[string]bool
+
+// partnerMetaMaxBytes (PARTNER_META_MAX_BYTES, 0 = no limit) is the largest partner meta that is
+// rewritten; a larger one is skipped as oversized-meta without being parsed.
+var partnerMetaMaxBytes int
+
+// quarantinePath (QUARANTINE_OUT) is a JSON-lines file (appended to) that receives one record
+// per anomalous row: an unparseable URL, a URL on an unexpected host, oversized or invalid
+// partner meta, a failed meta self-check or a value that would not fit its column. Those rows are never updated; the file is the list for
+// manual review, separate from ordinary skips, and MODE=review-quarantine prints it.
+var quarantinePath string
+
+// quarantineRecord is one quarantined row. Values holds the stored values of the offending
+// columns, so the row can be judged without querying it.
+type quarantineRecord struct {
+	Table    string            `json:"table"`
+	PKColumn string            `json:"pk_column"`
+	PK       pkValue           `json:"pk"`
+	RunID    string            `json:"run_id"`
+	DryRun   bool              `json:"dry_run"`
+	DSN      string            `json:"dsn,omitempty"`
+	Reason   string            `json:"reason"`
+	Detail   string            `json:"detail,omitempty"`
+	Values   map[string]string `json:"values"`
+	At       time.Time         `json:"at"`
+}
+
+var (
+	quarantineFile    *os.File
+	quarantineEncoder *json.Encoder
+)
+
+// openQuarantine opens (appends to) the QUARANTINE_OUT file.
+func openQuarantine(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	quarantineFile = f
+	quarantineEncoder = json.NewEncoder(f)
+	return nil
+}
+
+// quarantineRow writes a quarantine record for the row, if QUARANTINE_OUT is set. The caller
+// still skips the row and counts the reason.
+func quarantineRow(table, pkCol string, pk pkValue, dryRun bool, reason, detail string, values map[string]string) {
+	if quarantineEncoder == nil {
+		return
+	}
+	rec := quarantineRecord{
+		Table: table, PKColumn: pkCol, PK: pk, RunID: runID, DryRun: dryRun, DSN: currentDSN,
+		Reason: reason, Detail: detail, Values: values, At: time.Now().UTC(),
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if err := quarantineEncoder.Encode(rec); err != nil {
+		log.Printf("[WARN] failed to write quarantine record for %s %s=%s: %v", table, pkCol, pk, err)
+	}
+}
+
+// checkParseableURL returns the parse error of a stored URL value (storage prefix stripped).
+func checkParseableURL(raw string) error {
+	_, inner, _ := cutStoragePrefix(raw)
+	_, err := parseURL(inner)
+	return err
+}
+
+// checkExpectedHost returns an error if QUARANTINE_HOSTS is set and the host of a stored URL
+// value (storage prefix stripped) is not in it. Unparseable URLs are left to checkParseableURL.
+func checkExpectedHost(raw string) error {
+	if len(expectedHosts) == 0 {
+		return nil
+	}
+	_, inner, _ := cutStoragePrefix(raw)
+	u, err := parseURL(inner)
+	if err != nil {
+		return nil
+	}
+	if host := strings.ToLower(u.Hostname()); !expectedHosts[host] {
+		return fmt.Errorf("host %q is not in QUARANTINE_HOSTS", host)
+	}
+	return nil
+}
+
+// reviewQuarantine implements MODE=review-quarantine: it lists the rows of the QUARANTINE_OUT
+// file (only QUARANTINE_RUN_ID's, if set), one entry per table, pk and reason with the most
+// recent record and how often the row was quarantined, followed by counts per table and reason.
+// It does not connect to the database.
+func reviewQuarantine(ctx context.Context) error {
+	if quarantinePath == "" {
+		return &ConfigError{Key: "QUARANTINE_OUT", Err: errors.New("required with MODE=review-quarantine")}
+	}
+	onlyRunID := strings.TrimSpace(os.Getenv("QUARANTINE_RUN_ID"))
+
+	f, err := os.Open(quarantinePath)
+	if err != nil {
+		return &ConfigError{Key: "QUARANTINE_OUT", Err: err}
+	}
+	defer f.Close()
+
+	type entry struct {
+		last  quarantineRecord
+		count int
+	}
+	entries := make(map[string]*entry)
+	var order []string
+	dec := json.NewDecoder(f)
+	for {
+		if err := ctx.Err(); err != nil {
+			return context.Cause(ctx)
+		}
+		var rec quarantineRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read %s: %w", quarantinePath, err)
+		}
+		if onlyRunID != "" && rec.RunID != onlyRunID {
+			continue
+		}
+		key := rec.DSN + "\x00" + rec.Table + "\x00" + rec.PK.String() + "\x00" + rec.Reason
+		e, ok := entries[key]
+		if !ok {
+			e = &entry{}
+			entries[key] = e
+			order = append(order, key)
+		}
+		e.last = rec
+		e.count++
+	}
+
+	byReason := make(map[string]int)
+	for _, key := range order {
+		e := entries[key]
+		r := e.last
+		byReason[r.Table+" "+r.Reason]++
+		dsn := ""
+		if r.DSN != "" {
+			dsn = " dsn=" + r.DSN
+		}
+		log.Printf("[QUARANTINE] %s %s=%s reason=%s seen=%d last_run_id=%s dry_run=%v at=%s%s",
+			r.Table, r.PKColumn, r.PK, r.Reason, e.count, r.RunID, r.DryRun, r.At.Format(time.RFC3339), dsn)
+		if r.Detail != "" {
+			log.Printf("[QUARANTINE]   detail: %s", r.Detail)
+		}
+		cols := make([]string, 0, len(r.Values))
+		for c := range r.Values {
+			cols = append(cols, c)
+		}
+		sort.Strings(cols)
+		for _, c := range cols {
+			log.Printf("[QUARANTINE]   %s=%s", c, r.Values[c])
+		}
+	}
+
+	groups := make([]string, 0, len(byReason))
+	for g := range byReason {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		table, reason, _ := strings.Cut(g, " ")
+		log.Printf("[QUARANTINE][SUMMARY] table=%s reason=%s rows=%d", table, reason, byReason[g])
+	}
+	log.Printf("[QUARANTINE][SUMMARY] rows=%d file=%s", len(order), quarantinePath)
+	return nil
+}
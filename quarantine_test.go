@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withQuarantineFile points QUARANTINE_OUT at a temp file and returns a func reading its records.
+func withQuarantineFile(t *testing.T) func() []quarantineRecord {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "quarantine.jsonl")
+	if err := openQuarantine(path); err != nil {
+		t.Fatal(err)
+	}
+	quarantinePath = path
+	t.Cleanup(func() {
+		quarantineFile.Close()
+		quarantineFile, quarantineEncoder, quarantinePath = nil, nil, ""
+	})
+	return func() []quarantineRecord {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var recs []quarantineRecord
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var rec quarantineRecord
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+}
+
+func TestCheckExpectedHost(t *testing.T) {
+	withCleaningConfig(t)
+	t.Cleanup(func() { expectedHosts = nil })
+
+	expectedHosts = nil
+	if err := checkExpectedHost("https://anywhere.example.com/a.pdf"); err != nil {
+		t.Errorf("no QUARANTINE_HOSTS: %v", err)
+	}
+
+	expectedHosts = map[string]bool{"cdn.example.com": true}
+	storagePrefixes = []string{"s3://"}
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"https://cdn.example.com/a.pdf?tag=1", true},
+		{"https://CDN.Example.com:8443/a.pdf", true},
+		{"//cdn.example.com/a.pdf", true},
+		{"s3://https://cdn.example.com/a.pdf", true},
+		{"https://evil.example.com/a.pdf", false},
+		{"https://cdn.example.com.evil.net/a.pdf", false},
+		{"/relative/a.pdf", false},
+		{"http://[::1", true}, // unparseable: reported as unparseable-url instead
+	}
+	for _, tc := range tests {
+		if err := checkExpectedHost(tc.in); (err == nil) != tc.ok {
+			t.Errorf("checkExpectedHost(%q) = %v, want ok=%v", tc.in, err, tc.ok)
+		}
+	}
+}
+
+func TestQuarantineUnexpectedHost(t *testing.T) {
+	withCleaningConfig(t)
+	records := withQuarantineFile(t)
+	t.Cleanup(func() { expectedHosts = nil })
+	expectedHosts = map[string]bool{"dev-genesis.s3.ap-southeast-1.amazonaws.com": true}
+
+	stats := newMigrationStats()
+	bad := BulkRow{ID: 1, ArchiveFile: sql.NullString{String: "https://other.example.com/a.xlsx?tag=1", Valid: true}}
+	if _, skipped, err := processBulkRowRemoveTag(t.Context(), nil, bad, stats, true); err != nil || !skipped {
+		t.Fatalf("unexpected host: skipped=%v err=%v, want skipped", skipped, err)
+	}
+	good := BulkRow{ID: 2, ArchiveFile: sql.NullString{String: "https://dev-genesis.s3.ap-southeast-1.amazonaws.com/a.xlsx?tag=1", Valid: true}}
+	if _, skipped, err := processBulkRowRemoveTag(t.Context(), nil, good, stats, true); err != nil || skipped {
+		t.Fatalf("expected host: skipped=%v err=%v, want cleaned", skipped, err)
+	}
+
+	partner := PartnerRow{PartnerID: 3, Meta: sql.NullString{
+		String: `{"partner_pos_attach_files":["https://dev-genesis.s3.ap-southeast-1.amazonaws.com/a.pdf?tag=1","https://other.example.com/b.pdf"]}`,
+		Valid:  true,
+	}}
+	if _, skipped, err := processPartnerRowRemoveTag(t.Context(), nil, partner, stats, true); err != nil || !skipped {
+		t.Fatalf("partner with unexpected host: skipped=%v err=%v, want skipped", skipped, err)
+	}
+
+	if n := stats.skipReasons[skipUnexpectedHost]; n != 2 {
+		t.Errorf("skipReasons[%s] = %d, want 2", skipUnexpectedHost, n)
+	}
+	recs := records()
+	if len(recs) != 2 {
+		t.Fatalf("quarantined %d rows, want 2: %+v", len(recs), recs)
+	}
+	if r := recs[0]; r.Table != "bulk" || r.PK.String() != "1" || r.Reason != skipUnexpectedHost || r.Values["archive_file"] != bad.ArchiveFile.String {
+		t.Errorf("bulk record = %+v", r)
+	}
+	if r := recs[1]; r.Table != "partner" || r.PK.String() != "3" || r.Reason != skipUnexpectedHost {
+		t.Errorf("partner record = %+v", r)
+	}
+}
+
+func TestQuarantineOversizedMeta(t *testing.T) {
+	withCleaningConfig(t)
+	records := withQuarantineFile(t)
+	t.Cleanup(func() { partnerMetaMaxBytes = 0 })
+
+	meta := `{"partner_pos_attach_files":["https://h/a.pdf?tag=1"]}`
+	row := PartnerRow{PartnerID: 9, Meta: sql.NullString{String: meta, Valid: true}}
+
+	partnerMetaMaxBytes = len(meta)
+	stats := newMigrationStats()
+	if _, skipped, err := processPartnerRowRemoveTag(t.Context(), nil, row, stats, true); err != nil || skipped {
+		t.Fatalf("meta at the limit: skipped=%v err=%v, want cleaned", skipped, err)
+	}
+
+	partnerMetaMaxBytes = len(meta) - 1
+	stats = newMigrationStats()
+	if _, skipped, err := processPartnerRowRemoveTag(t.Context(), nil, row, stats, true); err != nil || !skipped {
+		t.Fatalf("oversized meta: skipped=%v err=%v, want skipped", skipped, err)
+	}
+	if stats.skipReasons[skipOversizedMeta] != 1 || stats.urlsCleaned != 0 {
+		t.Errorf("skipReasons=%v urlsCleaned=%d", stats.skipReasons, stats.urlsCleaned)
+	}
+	recs := records()
+	if len(recs) != 1 || recs[0].Reason != skipOversizedMeta || recs[0].PK.String() != "9" || recs[0].Values["meta"] != meta {
+		t.Fatalf("quarantine records = %+v", recs)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ------------------------------
+// Run modes (MODE)
+// ------------------------------
+
+const (
+	modeMigrate          = ""                  // default: run the migrations
+	modePrintQueries     = "print-queries"     // print the candidate SELECTs and exit
+	modePrefixAudit      = "prefix-audit"      // compare the client LIKE prefilter with hasHydraPrefix
+	modeReconcile        = "reconcile"         // check a change report against the live values
+	modeReclean          = "reclean"           // re-run the migrations on the rows a reported run changed
+	modeReviewQuarantine = "review-quarantine" // list the rows of a QUARANTINE_OUT file
+)
+
+var runMode string
+
+// batchQuery is the fetch query of one migration; build returns its first batch of up to limit rows.
+type batchQuery struct {
+	table, pkCol, selectCols string
+	build                    func(limit int) (string, []interface{})
+}
+
+// selectedBatchQueries lists the fetch queries of the migrations this run would execute.
+func selectedBatchQueries() []batchQuery {
+	all := []batchQuery{
+		{"bulk", "id", "id, archive_file", func(limit int) (string, []interface{}) { return bulkBatchQuery(context.Background(), 0, limit) }},
+		{"partner", "partner_id", "partner_id, meta", func(limit int) (string, []interface{}) { return partnerBatchQuery(context.Background(), 0, limit) }},
+		{"client", "client_id", "client_id, " + strings.Join(clientSelectColumns(), ", "), func(limit int) (string, []interface{}) { return clientBatchQuery(context.Background(), 0, limit) }},
+	}
+	for _, t := range append(append([]genericTable(nil), genericTables...), htmlColumns...) {
+		all = append(all, batchQuery{t.name(), t.PKColumn, fmt.Sprintf("%s AS pk, %s AS url", t.PKColumn, t.URLColumn),
+			func(limit int) (string, []interface{}) {
+				return genericBatchQuery(context.Background(), t, t.firstPK(), limit)
+			}})
+	}
+
+	var selected []batchQuery
+	for _, q := range all {
+		if !runsTable(q.table) || (q.table == "client" && len(clientAttachmentColumns) == 0) {
+			continue
+		}
+		selected = append(selected, q)
+	}
+	return selected
+}
+
+// printQueries writes the first-batch fetch query of every selected migration to stdout, with
+// the bound arguments listed separately, so they can be reviewed (and EXPLAINed) before a run.
+// Later batches differ only in the pk argument.
+func printQueries(batchSize int) error {
+	for _, c := range selectedBatchQueries() {
+		var (
+			query string
+			args  []interface{}
+		)
+		if usesIDList(c.table) {
+			var err error
+			query, args, err = idsBatchQuery(c.table, c.pkCol, c.selectCols, nextIDChunk(c.table, 0, batchSize))
+			if err != nil {
+				return fmt.Errorf("build %s query: %w", c.table, err)
+			}
+			fmt.Printf("-- %s (pk list: %d pks, first chunk of %d)\n", c.table, len(idsLists[c.table]), batchSize)
+		} else {
+			query, args = c.build(batchSize)
+			fmt.Printf("-- %s (first batch; later batches bind the last seen %s as arg 1)\n", c.table, c.pkCol)
+		}
+		fmt.Println(strings.TrimSpace(query) + ";")
+		for i, a := range args {
+			if pk, ok := a.(pkValue); ok {
+				a, _ = pk.Value()
+			}
+			if s, ok := a.(string); ok {
+				fmt.Printf("--   arg %d: %q\n", i+1, s)
+			} else {
+				fmt.Printf("--   arg %d: %v\n", i+1, a)
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
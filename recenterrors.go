@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------------
+// Recent errors recap (RECENT_ERRORS)
+// ------------------------------
+
+// recentErrorsMax (RECENT_ERRORS, default 20, 0 = off) is how many of the latest errors are kept
+// in memory and reprinted at the end of the run, so early failures do not scroll away unseen.
+// The ERROR_LOG_PATH file still has all of them.
+var recentErrorsMax = 20
+
+type recentError struct {
+	at   time.Time
+	kind string
+	meta string
+	err  string
+}
+
+// recentErrors is a ring buffer of the last recentErrorsMax errors; total counts all of them.
+var recentErrors struct {
+	sync.Mutex
+	buf   []recentError
+	next  int
+	total int
+}
+
+// rememberError adds one error to the ring buffer, overwriting the oldest once it is full.
+func rememberError(kind string, meta map[string]interface{}, err error) {
+	if recentErrorsMax <= 0 {
+		return
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, meta[k]))
+	}
+	e := recentError{at: time.Now(), kind: kind, meta: strings.Join(parts, " "), err: err.Error()}
+
+	recentErrors.Lock()
+	defer recentErrors.Unlock()
+	recentErrors.total++
+	if len(recentErrors.buf) < recentErrorsMax {
+		recentErrors.buf = append(recentErrors.buf, e)
+		return
+	}
+	recentErrors.buf[recentErrors.next] = e
+	recentErrors.next = (recentErrors.next + 1) % recentErrorsMax
+}
+
+// logRecentErrors reprints the buffered errors, oldest first.
+func logRecentErrors() {
+	recentErrors.Lock()
+	defer recentErrors.Unlock()
+	if recentErrors.total == 0 {
+		return
+	}
+	n := len(recentErrors.buf)
+	log.Printf("[SUMMARY] errors=%d, last %d:", recentErrors.total, n)
+	for i := 0; i < n; i++ {
+		e := recentErrors.buf[(recentErrors.next+i)%n]
+		log.Printf("[SUMMARY]   %s %s %s: %s", e.at.Format(time.TimeOnly), e.kind, e.meta, e.err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ------------------------------
+// MODE=reclean: re-run on a prior run's rows
+// ------------------------------
+
+// loadRecleanIDs reads the change records of a REPORT_OUT file (RECLEAN_REPORT) and returns the
+// pks each table had changed by run runID (RECLEAN_RUN_ID), for a rerun of the migrations on
+// exactly those rows with the current cleaning rules, e.g. after adding a TAG_PARAMS entry.
+// Dry-run records are ignored. Tables with string pks cannot be fetched by pk list and are
+// left out with a warning.
+func loadRecleanIDs(path, runID string) (map[string][]int64, error) {
+	path, runID = strings.TrimSpace(path), strings.TrimSpace(runID)
+	if path == "" {
+		return nil, &ConfigError{Key: "RECLEAN_REPORT", Err: errors.New("required with MODE=reclean")}
+	}
+	if runID == "" {
+		return nil, &ConfigError{Key: "RECLEAN_RUN_ID", Err: errors.New("required with MODE=reclean")}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &ConfigError{Key: "RECLEAN_REPORT", Err: err}
+	}
+	defer f.Close()
+
+	seen := make(map[string]map[int64]bool)
+	stringPKs := make(map[string]int)
+	dec := json.NewDecoder(f)
+	for {
+		var rec changeRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, &ConfigError{Key: "RECLEAN_REPORT", Err: fmt.Errorf("read %s: %w", path, err)}
+		}
+		if rec.DryRun || rec.RunID != runID || len(rec.Columns) == 0 {
+			continue
+		}
+		if rec.PK.isStr {
+			stringPKs[rec.Table]++
+			continue
+		}
+		if seen[rec.Table] == nil {
+			seen[rec.Table] = make(map[int64]bool)
+		}
+		seen[rec.Table][rec.PK.n] = true
+	}
+	for table, n := range stringPKs {
+		log.Printf("[WARN] MODE=reclean: %s has string pks, skipping its %d rows", table, n)
+	}
+
+	lists := make(map[string][]int64, len(seen))
+	for _, table := range allTables() {
+		pks, ok := seen[table]
+		if !ok {
+			continue
+		}
+		delete(seen, table)
+		ids := make([]int64, 0, len(pks))
+		for id := range pks {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		lists[table] = ids
+		log.Printf("MODE=reclean: processing %d %s pks changed by run %s", len(ids), table, runID)
+	}
+	for table, pks := range seen {
+		log.Printf("[WARN] MODE=reclean: %s is not configured in this run (EXTRA_TABLES?), skipping its %d rows", table, len(pks))
+	}
+	if len(lists) == 0 {
+		return nil, &ConfigError{Key: "RECLEAN_RUN_ID", Err: fmt.Errorf("no applied changes of run %s in %s", runID, path)}
+	}
+	return lists, nil
+}
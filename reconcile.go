@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// MODE=reconcile: report vs live DB
+// ------------------------------
+
+// errReconcileMismatch is returned when at least one reported change is not live.
+var errReconcileMismatch = errors.New("reconcile: live values differ from the report")
+
+// reconcile reads the change records of RECONCILE_REPORT (a REPORT_OUT file) and checks that
+// every column of every applied record currently holds its reported new value. Dry-run records
+// are ignored; RECONCILE_RUN_ID restricts the check to one run. Rows still holding the old value
+// are reported as not applied, anything else as changed since. Read-only.
+func reconcile(ctx context.Context, db *sqlx.DB) error {
+	path := strings.TrimSpace(os.Getenv("RECONCILE_REPORT"))
+	if path == "" {
+		return &ConfigError{Key: "RECONCILE_REPORT", Err: errors.New("required with MODE=reconcile")}
+	}
+	onlyRunID := strings.TrimSpace(os.Getenv("RECONCILE_RUN_ID"))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return &ConfigError{Key: "RECONCILE_REPORT", Err: err}
+	}
+	defer f.Close()
+
+	var checked, matched, notApplied, changedSince, missing, ignored int
+	dec := json.NewDecoder(f)
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("reconcile interrupted after %d records: %w", checked, context.Cause(ctx))
+		}
+		var rec changeRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		if rec.DryRun || (onlyRunID != "" && rec.RunID != onlyRunID) || len(rec.Columns) == 0 {
+			ignored++
+			continue
+		}
+		checked++
+
+		live, err := liveColumnValues(ctx, db, rec)
+		if errors.Is(err, sql.ErrNoRows) {
+			missing++
+			log.Printf("[RECONCILE][MISSING] %s %s=%s no longer exists", rec.Table, rec.PKColumn, rec.PK)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		ok := true
+		for i, c := range rec.Columns {
+			v := live[i]
+			if v.Valid && v.String == c.New {
+				continue
+			}
+			ok = false
+			status := "CHANGED-SINCE"
+			if (c.OldNull && !v.Valid) || (!c.OldNull && v.Valid && v.String == c.Old) {
+				status = "NOT-APPLIED"
+			}
+			log.Printf("[RECONCILE][%s] %s %s=%s %s run_id=%s\nexpected=%s\nlive=%s",
+				status, rec.Table, rec.PKColumn, rec.PK, c.Name, rec.RunID, c.New, nullableString(v))
+			if status == "NOT-APPLIED" {
+				notApplied++
+			} else {
+				changedSince++
+			}
+		}
+		if ok {
+			matched++
+		}
+	}
+
+	log.Printf("[RECONCILE][SUMMARY] records=%d matched=%d notAppliedColumns=%d changedSinceColumns=%d missingRows=%d ignored=%d",
+		checked, matched, notApplied, changedSince, missing, ignored)
+	if notApplied+changedSince+missing > 0 {
+		return errReconcileMismatch
+	}
+	return nil
+}
+
+// liveColumnValues reads the current values of rec's columns, in rec.Columns order. The table
+// and column names come from the report file, so they are checked before being used in SQL.
+func liveColumnValues(ctx context.Context, db *sqlx.DB, rec changeRecord) ([]sql.NullString, error) {
+	names := []string{rec.Table, rec.PKColumn}
+	cols := make([]string, 0, len(rec.Columns))
+	for _, c := range rec.Columns {
+		names = append(names, c.Name)
+		cols = append(cols, c.Name)
+	}
+	for _, n := range names {
+		if !isSQLIdentifier(n) {
+			return nil, fmt.Errorf("report record %s=%s: invalid identifier %q", rec.PKColumn, rec.PK, n)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", strings.Join(cols, ", "), rec.Table, rec.PKColumn)
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := db.QueryRowxContext(ctx, query, rec.PK).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, &DBError{Op: "select " + rec.Table, Err: err}
+	}
+	return values, nil
+}
+
+func nullableString(v sql.NullString) string {
+	if !v.Valid {
+		return "NULL"
+	}
+	return v.String
+}
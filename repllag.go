@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Replication lag gate (REPL_LAG_QUERY / MAX_REPL_LAG)
+// ------------------------------
+
+// replLagQuery (REPL_LAG_QUERY, e.g. "SHOW SLAVE STATUS" or a heartbeat SELECT) is run before
+// every batch; while the lag it reports exceeds maxReplLag (MAX_REPL_LAG seconds, default 30)
+// the migration waits. Empty disables the gate. The query runs on REPL_LAG_DSN if set (usually
+// the replica), otherwise on DB_DSN.
+var (
+	replLagQuery string
+	maxReplLag   = 30
+	replLagDB    *sqlx.DB
+)
+
+// lagColumns are the SHOW SLAVE/REPLICA STATUS columns holding the lag in seconds.
+var lagColumns = []string{"Seconds_Behind_Master", "Seconds_Behind_Source"}
+
+// openReplLagDB picks the connection for the lag query and checks that the query works.
+func openReplLagDB(ctx context.Context, db *sqlx.DB, dsn string) error {
+	if replLagQuery == "" {
+		return nil
+	}
+	replLagDB = db
+	if dsn != "" {
+		lagDB, err := sqlx.Open(sqlDialect.driver, dsn)
+		if err != nil {
+			return &ConfigError{Key: "REPL_LAG_DSN", Err: err}
+		}
+		replLagDB = lagDB
+	}
+	lag, err := replicationLag(ctx)
+	if err != nil {
+		closeReplLagDB(db)
+		return &ConfigError{Key: "REPL_LAG_QUERY", Err: err}
+	}
+	log.Printf("replication lag gate: MAX_REPL_LAG=%ds, current lag=%s", maxReplLag, formatLag(lag))
+	return nil
+}
+
+// closeReplLagDB closes the REPL_LAG_DSN connection, if one was opened.
+func closeReplLagDB(db *sqlx.DB) {
+	if replLagDB != nil && replLagDB != db {
+		replLagDB.Close()
+	}
+}
+
+// replicationLag runs the lag query. It reads a Seconds_Behind_* column if there is one,
+// otherwise the first column of the first row. A NULL lag (replication stopped) is returned as -1.
+func replicationLag(ctx context.Context) (int64, error) {
+	rows, err := replLagDB.QueryxContext(ctx, replLagQuery)
+	if err != nil {
+		return 0, &DBError{Op: "replication lag", Err: err}
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, &DBError{Op: "replication lag", Err: err}
+		}
+		return 0, errors.New("lag query returned no rows (not a replica?)")
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, &DBError{Op: "replication lag", Err: err}
+	}
+
+	v := values[0]
+	for i, c := range cols {
+		for _, name := range lagColumns {
+			if strings.EqualFold(c, name) {
+				v = values[i]
+			}
+		}
+	}
+	if !v.Valid {
+		return -1, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v.String), 64)
+	if err != nil {
+		return 0, fmt.Errorf("lag value %q is not a number", v.String)
+	}
+	return int64(f), nil
+}
+
+func formatLag(lag int64) string {
+	if lag < 0 {
+		return "NULL (replication not running)"
+	}
+	return fmt.Sprintf("%ds", lag)
+}
+
+// waitForReplication blocks while the replication lag exceeds MAX_REPL_LAG, polling like
+// waitWhilePaused. An unknown lag (NULL, or a failing query) counts as too high. It returns early
+// if ctx is cancelled.
+func waitForReplication(ctx context.Context, label string) {
+	if replLagQuery == "" {
+		return
+	}
+	var pausedAt time.Time
+	for {
+		lag, err := replicationLag(ctx)
+		if err == nil && lag >= 0 && lag <= int64(maxReplLag) {
+			if !pausedAt.IsZero() {
+				log.Printf("[%s] replication lag %ds within MAX_REPL_LAG=%ds, resuming after %s",
+					label, lag, maxReplLag, time.Since(pausedAt).Round(time.Second))
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if pausedAt.IsZero() {
+			pausedAt = time.Now()
+		}
+		if err != nil {
+			log.Printf("[%s] PAUSED: replication lag unknown: %v (paused for %s)", label, err, time.Since(pausedAt).Round(time.Second))
+		} else {
+			log.Printf("[%s] PAUSED: replication lag %s exceeds MAX_REPL_LAG=%ds (paused for %s)",
+				label, formatLag(lag), maxReplLag, time.Since(pausedAt).Round(time.Second))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pausePollInterval):
+		}
+	}
+}
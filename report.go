@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ------------------------------
+// Change records / report
+// ------------------------------
+
+// changeRecord is one logical change event: all cleaned columns of a single row.
+// It feeds both the REPORT_OUT file and the rollback SQL script.
+type changeRecord struct {
+	Table    string         `json:"table"`
+	PKColumn string         `json:"pk_column"`
+	PK       pkValue        `json:"pk"`
+	RunID    string         `json:"run_id"`
+	DryRun   bool           `json:"dry_run"`
+	DSN      string         `json:"dsn,omitempty"`
+	Columns  []columnChange `json:"columns"`
+}
+
+// columnChange is the old/new value of one column within a changeRecord. Removed lists the
+// tag params dropped from its URL(s) as raw key=value pairs (REPORT_REMOVED_PARAMS=1 only);
+// removed always holds them, for the removal counts of recordChange.
+type columnChange struct {
+	Name    string   `json:"name"`
+	Old     string   `json:"old"`
+	OldNull bool     `json:"old_null,omitempty"`
+	New     string   `json:"new"`
+	Removed []string `json:"removed,omitempty"`
+
+	removed []string
+}
+
+// reportRemovedParams (REPORT_REMOVED_PARAMS=1) fills columnChange.Removed.
+var reportRemovedParams bool
+
+// newChangeRecord builds a record for table/pk. Columns are added with addColumn.
+func newChangeRecord(table, pkCol string, pk pkValue, dryRun bool) changeRecord {
+	return changeRecord{Table: table, PKColumn: pkCol, PK: pk, RunID: runID, DryRun: dryRun, DSN: currentDSN}
+}
+
+// addColumn adds one changed column; removed are the tag param pairs dropped from its URL(s).
+func (r *changeRecord) addColumn(name, oldValue, newValue string, removed ...string) {
+	c := columnChange{Name: name, Old: oldValue, New: newValue, removed: removed}
+	if reportRemovedParams {
+		c.Removed = removed
+	}
+	r.Columns = append(r.Columns, c)
+}
+
+// addNullableColumn is addColumn for an old value that may be NULL (recorded as OldNull).
+func (r *changeRecord) addNullableColumn(name string, oldValue sql.NullString, newValue string, removed ...string) {
+	r.addColumn(name, oldValue.String, newValue, removed...)
+	r.Columns[len(r.Columns)-1].OldNull = !oldValue.Valid
+}
+
+// sortColumns orders columns by name so output is stable across runs.
+func (r *changeRecord) sortColumns() {
+	sort.Slice(r.Columns, func(i, j int) bool { return r.Columns[i].Name < r.Columns[j].Name })
+}
+
+var (
+	reportFile    *os.File
+	reportEncoder *json.Encoder
+)
+
+// openReport opens (appends to) the REPORT_OUT file; one JSON changeRecord per line.
+func openReport(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	reportFile = f
+	reportEncoder = json.NewEncoder(f)
+	return nil
+}
+
+// recordMu serializes the report and rollback writers across PARALLEL_TABLES migrations.
+var recordMu sync.Mutex
+
+// recordChange writes rec to the report and, for applied changes, to the rollback script, and
+// counts its removed tag params. It is best-effort: write failures are logged, never returned.
+func recordChange(rec changeRecord) {
+	rec.sortColumns()
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	if reportEncoder != nil {
+		if err := reportEncoder.Encode(rec); err != nil {
+			log.Printf("[WARN] failed to write report record for %s %s=%s: %v", rec.Table, rec.PKColumn, rec.PK, err)
+		}
+	}
+	if !rec.DryRun {
+		writeRollbackSQL(rec)
+	}
+	addChangelogSample(rec)
+	addPurgeURLs(rec)
+	for _, c := range rec.Columns {
+		countRemovedParams(c.removed)
+	}
+}
+
+// eligibleUnchangedRecord is a row the SQL prefilter selected as a candidate but in which Go found
+// nothing to clean, e.g. because the tag param is spelled differently.
+type eligibleUnchangedRecord struct {
+	Table    string            `json:"table"`
+	PKColumn string            `json:"pk_column"`
+	PK       int64             `json:"pk"`
+	RunID    string            `json:"run_id"`
+	Values   map[string]string `json:"values"`
+}
+
+var (
+	eligibleUnchangedFile    *os.File
+	eligibleUnchangedEncoder *json.Encoder
+)
+
+// openEligibleUnchangedReport opens (appends to) the ELIGIBLE_UNCHANGED_REPORT file.
+func openEligibleUnchangedReport(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	eligibleUnchangedFile = f
+	eligibleUnchangedEncoder = json.NewEncoder(f)
+	return nil
+}
+
+// recordEligibleUnchanged writes one eligible-but-unchanged row, if the report is enabled.
+func recordEligibleUnchanged(table, pkCol string, pk int64, values map[string]string) {
+	if eligibleUnchangedEncoder == nil {
+		return
+	}
+	rec := eligibleUnchangedRecord{Table: table, PKColumn: pkCol, PK: pk, RunID: runID, Values: values}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if err := eligibleUnchangedEncoder.Encode(rec); err != nil {
+		log.Printf("[WARN] failed to write eligible-unchanged record for %s %s=%d: %v", table, pkCol, pk, err)
+	}
+}
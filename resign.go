@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ------------------------------
+// Re-signing (RESIGN_URL, RESIGN_COLUMNS)
+// ------------------------------
+
+// skipResignFailed is the skip reason for a row whose cleaned URL could not be re-signed.
+const skipResignFailed = "resign-failed"
+
+// resignEndpoint is the RESIGN_URL endpoint that returns a fresh signed URL for a cleaned one.
+// Removing tag from a signed hydra URL can invalidate its signature, so the columns listed in
+// resignColumns get the endpoint's URL written instead of the cleaned one.
+var resignEndpoint string
+
+// resignColumns are the "table.column" entries of RESIGN_COLUMNS whose cleaned URLs are
+// re-signed. Only plain URL columns can be listed: bulk.archive_file, the client attachment
+// columns and EXTRA_TABLES URL columns.
+var resignColumns = make(map[string]bool)
+
+// resignClient is the HTTP client for RESIGN_URL calls (RESIGN_TIMEOUT, default 10s).
+var resignClient = &http.Client{Timeout: 10 * time.Second}
+
+// parseResignColumns parses the RESIGN_COLUMNS spec. Must run after EXTRA_TABLES is parsed.
+func parseResignColumns(spec string) (map[string]bool, error) {
+	allowed := map[string]bool{"bulk.archive_file": true}
+	for _, col := range clientAttachmentColumns {
+		allowed["client."+col] = true
+	}
+	for _, t := range genericTables {
+		allowed[t.Table+"."+t.URLColumn] = true
+	}
+
+	cols := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !allowed[entry] {
+			return nil, &ConfigError{Key: "RESIGN_COLUMNS", Err: fmt.Errorf("entry %q is not a URL column (bulk.archive_file, client attachment columns or EXTRA_TABLES)", entry)}
+		}
+		cols[entry] = true
+	}
+	return cols, nil
+}
+
+// resigns reports whether cleaned URLs of table.column are re-signed.
+func resigns(table, column string) bool {
+	return resignColumns[table+"."+column]
+}
+
+// resignURL asks RESIGN_URL for a signed version of the cleaned URL. The endpoint receives
+// {"url": "<cleaned>"} and must answer 2xx with {"url": "<signed>"}. A storage prefix is kept
+// out of the request and put back in front of the signed URL.
+func resignURL(ctx context.Context, cleaned string) (string, error) {
+	prefix, inner, _ := cutStoragePrefix(cleaned)
+	body, err := json.Marshal(map[string]string{"url": inner})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resignEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := resignClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("resign endpoint returned %s", resp.Status)
+	}
+
+	var out struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode resign response: %w", err)
+	}
+	signed := strings.TrimSpace(out.URL)
+	if signed == "" {
+		return "", errors.New("resign response has no url")
+	}
+	if _, err := parseURL(signed); err != nil {
+		return "", fmt.Errorf("resign response url: %w", err)
+	}
+	return prefix + signed, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ------------------------------
+// Rollback SQL script
+// ------------------------------
+
+var rollbackSQLFile *os.File
+
+// auditDisabled holds the tables whose rollback SQL is not written (AUDIT_<TABLE>=0, e.g.
+// AUDIT_BULK=0 for URLs that can be regenerated). Updates happen regardless.
+var auditDisabled map[string]bool
+
+// openRollbackSQL opens (appends to) the ROLLBACK_SQL_OUT file and writes a header with the run id.
+func openRollbackSQL(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("-- rollback-url-tagging rollback script\n-- run_id: %s\n-- generated_at: %s\n",
+		runID, time.Now().Format(time.RFC3339))
+	if _, err := f.WriteString(header); err != nil {
+		f.Close()
+		return err
+	}
+	rollbackSQLFile = f
+	return nil
+}
+
+// writeRollbackSQL appends the inverse UPDATE restoring the old values of rec, one statement per row.
+// It is best-effort: write failures are logged, never returned.
+func writeRollbackSQL(rec changeRecord) {
+	if rollbackSQLFile == nil || len(rec.Columns) == 0 || auditDisabled[rec.Table] {
+		return
+	}
+
+	setParts := make([]string, 0, len(rec.Columns))
+	for _, c := range rec.Columns {
+		old := sqlDialect.quoteString(c.Old)
+		if c.OldNull {
+			old = "NULL"
+		}
+		setParts = append(setParts, fmt.Sprintf("%s = %s", c.Name, old))
+	}
+	if col, ok := markColumns[rec.Table]; ok {
+		// Clear the marker too, so a later run picks the restored row up again.
+		setParts = append(setParts, fmt.Sprintf("%s = NULL", col))
+	}
+
+	stmt := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s;\n", rec.Table, strings.Join(setParts, ", "), rec.PKColumn, rec.PK.sqlLiteral())
+	if _, err := rollbackSQLFile.WriteString(stmt); err != nil {
+		log.Printf("[WARN] failed to write rollback SQL for %s %s=%s: %v", rec.Table, rec.PKColumn, rec.PK, err)
+	}
+}
+
+// quoteSQLString returns s as a single-quoted MySQL string literal with special characters escaped.
+func quoteSQLString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		case '\\', '\'', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
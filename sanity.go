@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Candidate count guard (SANITY_MAX_CANDIDATES)
+// ------------------------------
+
+// sanityMaxCandidates (SANITY_MAX_CANDIDATES) is the most candidate rows a single migration may
+// match before the run refuses to start (0 = no check). sanityOverride (SANITY_OVERRIDE=1)
+// downgrades the refusal to a warning, for runs that are known to be that broad.
+var (
+	sanityMaxCandidates int
+	sanityOverride      bool
+)
+
+// errTooManyCandidates is returned when a migration matches more than SANITY_MAX_CANDIDATES rows.
+var errTooManyCandidates = errors.New("too many candidate rows")
+
+// checkCandidateCounts counts the candidates of every selected migration with its own fetch
+// query (so exactly the run's filters apply), capped at one row over the threshold so the count
+// stays cheap on huge tables. IDS_FILE tables are bounded by their list and not counted. Dry runs
+// only warn.
+func checkCandidateCounts(ctx context.Context, db *sqlx.DB, dryRun bool) error {
+	if sanityMaxCandidates <= 0 {
+		return nil
+	}
+	for _, q := range selectedBatchQueries() {
+		if usesIDList(q.table) {
+			continue
+		}
+		query, args := q.build(sanityMaxCandidates + 1)
+		var n int
+		if err := db.GetContext(ctx, &n, "SELECT COUNT(*) FROM ("+query+") AS candidates", args...); err != nil {
+			return &DBError{Op: "count " + q.table + " candidates", Err: err}
+		}
+		if n <= sanityMaxCandidates {
+			log.Printf("sanity check: %s candidates=%d (max %d)", q.table, n, sanityMaxCandidates)
+			continue
+		}
+		if sanityOverride || dryRun {
+			log.Printf("[WARN] sanity check: %s matches more than SANITY_MAX_CANDIDATES=%d rows, continuing (SANITY_OVERRIDE=%v dryRun=%v)",
+				q.table, sanityMaxCandidates, sanityOverride, dryRun)
+			continue
+		}
+		return fmt.Errorf("%s matches more than SANITY_MAX_CANDIDATES=%d rows; check the filters, or set SANITY_OVERRIDE=1 if this is intended: %w",
+			q.table, sanityMaxCandidates, errTooManyCandidates)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Schema drift
+// ------------------------------
+
+// adaptToSchema drops configured URL columns that do not exist in this database, so an older
+// schema (e.g. without client_pks_attachment) degrades to cleaning the remaining columns instead
+// of failing the whole migration on the SELECT. Every dropped column is logged as a warning.
+func adaptToSchema(ctx context.Context, db *sqlx.DB) error {
+	if runsTable("client") {
+		cols, err := sqlDialect.tableColumns(ctx, db, "client")
+		if err != nil {
+			return &DBError{Op: "inspect client", Err: err}
+		}
+		present := make([]string, 0, len(clientAttachmentColumns))
+		for _, col := range clientAttachmentColumns {
+			if !cols[col] {
+				log.Printf("[WARN] column client.%s does not exist, skipping it", col)
+				continue
+			}
+			present = append(present, col)
+		}
+		if len(present) == 0 {
+			log.Printf("[WARN] client has none of %s, skipping the client migration", strings.Join(clientAttachmentColumns, ", "))
+		}
+		clientAttachmentColumns = present
+	}
+
+	kept := genericTables[:0]
+	for _, t := range genericTables {
+		cols, err := sqlDialect.tableColumns(ctx, db, t.Table)
+		if err != nil {
+			return &DBError{Op: "inspect " + t.Table, Err: err}
+		}
+		if !cols[t.URLColumn] {
+			log.Printf("[WARN] column %s.%s does not exist, skipping the %s migration", t.Table, t.URLColumn, t.Table)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	genericTables = kept
+
+	keptHTML := htmlColumns[:0]
+	for _, t := range htmlColumns {
+		cols, err := sqlDialect.tableColumns(ctx, db, t.Table)
+		if err != nil {
+			return &DBError{Op: "inspect " + t.Table, Err: err}
+		}
+		if !cols[t.URLColumn] {
+			log.Printf("[WARN] column %s does not exist, skipping the %s migration", t.name(), t.name())
+			continue
+		}
+		keptHTML = append(keptHTML, t)
+	}
+	htmlColumns = keptHTML
+	return nil
+}
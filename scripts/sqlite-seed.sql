@@ -0,0 +1,43 @@
+-- Local SQLite fixture for DB_DRIVER=sqlite runs:
+--   sqlite3 local.db < scripts/sqlite-seed.sql
+--   DB_DRIVER=sqlite DB_DSN=local.db DRY_RUN=1 go run .
+
+CREATE TABLE IF NOT EXISTS bulk (
+    id INTEGER PRIMARY KEY,
+    archive_type TEXT NOT NULL,
+    archive_file TEXT,
+    created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS partner (
+    partner_id INTEGER PRIMARY KEY,
+    meta TEXT,
+    partner_is_banned INTEGER NOT NULL DEFAULT 0,
+    partner_contract_end TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS client (
+    client_id INTEGER PRIMARY KEY,
+    client_contract_attachment_url TEXT,
+    client_tax_attachment TEXT,
+    client_pks_attachment TEXT,
+    client_is_banned INTEGER NOT NULL DEFAULT 0,
+    client_contract_end_date TEXT NOT NULL
+);
+
+INSERT OR REPLACE INTO bulk (id, archive_type, archive_file, created_at) VALUES
+    (1, 'custom_client_rate', 'https://old-bucket.s3.amazonaws.com/uploads/bulk_upload_client_rate_1.xlsx?tag=abc', datetime('now', '-1 day')),
+    (2, 'custom_client_rate', 'https://dev-genesis.s3.ap-southeast-1.amazonaws.com/bulk_upload_client_rate_2.xlsx', datetime('now', '-2 day')),
+    (3, 'custom_client_rate', '', datetime('now', '-3 day')),
+    (4, 'custom_client_rate', '//old-bucket.s3.amazonaws.com/uploads/bulk_upload_client_rate_4.xlsx?tag=def', datetime('now', '-4 day'));
+
+INSERT OR REPLACE INTO partner (partner_id, meta, partner_is_banned, partner_contract_end) VALUES
+    (1, '{"partner_pos_attach_files":["https://cdn.example.com/a.pdf?tag=x&v=1","https://cdn.example.com/b.pdf"]}', 0, datetime('now', '+1 year')),
+    (2, '{"partner_pos_attach_files":[]}', 0, datetime('now', '+1 year')),
+    (3, 'not json', 0, datetime('now', '+1 year')),
+    (4, '{"partner_pos_attach_files":["//cdn.example.com/c.pdf?tagging=y&v=2"]}', 0, datetime('now', '+1 year'));
+
+INSERT OR REPLACE INTO client (client_id, client_contract_attachment_url, client_tax_attachment, client_pks_attachment, client_is_banned, client_contract_end_date) VALUES
+    (1, 'https://api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?key=contract.pdf&tag=a', NULL, 'https://api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?key=pks.pdf&tagging=b', 0, datetime('now', '+1 year')),
+    (2, 'https://api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?key=contract.pdf', NULL, NULL, 0, datetime('now', '+1 year')),
+    (3, '//api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?key=contract.pdf&tag=c', NULL, NULL, 0, datetime('now', '+1 year'));
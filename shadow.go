@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Shadow apply (SHADOW_APPLY=1)
+// ------------------------------
+
+// shadowApply redirects every write to a <table>_shadow copy instead of the real table.
+var shadowApply bool
+
+// targetTable returns the table that updates for table should be written to.
+func targetTable(table string) string {
+	if shadowApply {
+		return table + "_shadow"
+	}
+	return table
+}
+
+// ensureShadowTable creates <table>_shadow with the same structure as table if it does not exist.
+func ensureShadowTable(ctx context.Context, db *sqlx.DB, table string) error {
+	return sqlDialect.createTableLike(ctx, db, targetTable(table), table)
+}
+
+// copyToShadow copies the given candidate rows into the shadow table. Rows already present are
+// left as they are, so re-running continues from the shadow state.
+func copyToShadow[PK int64 | pkValue](ctx context.Context, db *sqlx.DB, table, pkCol string, ids []PK) error {
+	if !shadowApply || len(ids) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In(
+		fmt.Sprintf("%s INTO %s SELECT * FROM %s WHERE %s IN (?)", sqlDialect.insertIgnore(), targetTable(table), table, pkCol),
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
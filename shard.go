@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Pk range sharding (SHARDS)
+// ------------------------------
+
+// shardCount (SHARDS, default 1 = off) splits the pk space of every selected integer-pk migration
+// into this many contiguous ranges between its MIN and MAX pk, each paginated concurrently by its
+// own goroutine and connection. The shards share MAX_WRITES, PAUSE_FILE, MAX_REPL_LAG and the
+// output files like PARALLEL_TABLES migrations do, and BATCH_SLEEP applies to each of them.
+var shardCount = 1
+
+// pkRange is the pk range (lo, hi] of one shard.
+type pkRange struct {
+	lo, hi    int64
+	shard, of int
+}
+
+type shardKey struct{}
+
+// shardStatsKey is the context key of the shardStats the shards of a migration merge into.
+type shardStatsKey struct{}
+
+// shardStats collects the stats of the shards of one migration.
+type shardStats struct {
+	mu    sync.Mutex
+	stats *migrationStats
+}
+
+func (m *shardStats) add(s *migrationStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.merge(s)
+}
+
+// shardOf returns the pk range the migration running under ctx is restricted to, if any.
+func shardOf(ctx context.Context) (pkRange, bool) {
+	r, ok := ctx.Value(shardKey{}).(pkRange)
+	return r, ok
+}
+
+// shardStart is the pk a migration running under ctx starts after: the start of its shard, else 0.
+func shardStart(ctx context.Context) int64 {
+	if r, ok := shardOf(ctx); ok {
+		return r.lo
+	}
+	return 0
+}
+
+// shardEndSQL is the batch query condition that keeps the fetch of a shard within its range, so
+// a shard whose tail has few candidates does not scan on to the end of the table to fill LIMIT.
+func shardEndSQL(ctx context.Context, pkCol string) (string, []interface{}) {
+	r, ok := shardOf(ctx)
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprintf("\n    AND %s <= ?", pkCol), []interface{}{r.hi}
+}
+
+// clipToShard drops the rows past the end of the shard of ctx. The batch queries already stop
+// at the end (see shardEndSQL); this is the safety net for a fetch that does not.
+func clipToShard[R any](ctx context.Context, rows []R, pk func(R) int64) []R {
+	r, ok := shardOf(ctx)
+	if !ok {
+		return rows
+	}
+	for i, row := range rows {
+		if pk(row) > r.hi {
+			return rows[:i]
+		}
+	}
+	return rows
+}
+
+// shardTarget returns the table and integer pk column migration name pages over, or false if it
+// cannot be sharded (string pks, pk lists).
+func shardTarget(name string) (table, pkCol string, ok bool) {
+	switch name {
+	case "bulk":
+		table, pkCol = "bulk", "id"
+	case "partner":
+		table, pkCol = "partner", "partner_id"
+	case "client":
+		table, pkCol = "client", "client_id"
+	default:
+		for _, t := range append(append([]genericTable(nil), genericTables...), htmlColumns...) {
+			if t.name() == name && !t.StringPK {
+				table, pkCol = t.Table, t.PKColumn
+			}
+		}
+	}
+	if table == "" || usesIDList(table) {
+		return "", "", false
+	}
+	return table, pkCol, true
+}
+
+// splitPKRange splits [min, max] into up to n contiguous shards of about equal width.
+func splitPKRange(min, max int64, n int) []pkRange {
+	width := (max - min + int64(n)) / int64(n)
+	if width < 1 {
+		width = 1
+	}
+	var ranges []pkRange
+	for lo := min - 1; lo < max; lo += width {
+		hi := lo + width
+		if hi > max {
+			hi = max
+		}
+		ranges = append(ranges, pkRange{lo: lo, hi: hi})
+	}
+	for i := range ranges {
+		ranges[i].shard, ranges[i].of = i+1, len(ranges)
+	}
+	return ranges
+}
+
+// runMigrationSharded runs migration name split into SHARDS pk ranges, or as a single migration
+// if sharding is off or does not apply. The first failing shard cancels the others; reaching
+// MAX_WRITES does not. Each shard logs its own totals; the totals and the host, skip, canary,
+// verify and longest-URL stats of all shards are merged into one summary for the migration.
+func runMigrationSharded(ctx context.Context, db *sqlx.DB, name string, dryRun bool, batchSize int) error {
+	table, pkCol, ok := shardTarget(name)
+	if shardCount <= 1 || !ok {
+		return runMigration(ctx, db, name, dryRun, batchSize)
+	}
+
+	var bounds struct {
+		Min sql.NullInt64 `db:"min_pk"`
+		Max sql.NullInt64 `db:"max_pk"`
+	}
+	if err := db.GetContext(ctx, &bounds, fmt.Sprintf("SELECT MIN(%s) AS min_pk, MAX(%s) AS max_pk FROM %s", pkCol, pkCol, table)); err != nil {
+		return &DBError{Op: "select " + table + " pk range", Err: err}
+	}
+	if !bounds.Min.Valid {
+		return runMigration(ctx, db, name, dryRun, batchSize)
+	}
+	ranges := splitPKRange(bounds.Min.Int64, bounds.Max.Int64, shardCount)
+	label := strings.ToUpper(name)
+	for _, r := range ranges {
+		log.Printf("[%s] shard %d/%d: %s range (%d, %d]", label, r.shard, r.of, pkCol, r.lo, r.hi)
+	}
+	// Every shard holds at least one connection while it runs.
+	if stats := db.Stats(); stats.MaxOpenConnections > 0 && stats.MaxOpenConnections < len(ranges) {
+		db.SetMaxOpenConns(len(ranges))
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	merged := &shardStats{stats: newMigrationStats()}
+	runCtx = context.WithValue(runCtx, shardStatsKey{}, merged)
+
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runMigration(context.WithValue(runCtx, shardKey{}, r), db, name, dryRun, batchSize)
+			if err != nil && !errors.Is(err, errMaxWritesReached) && ctx.Err() == nil {
+				cancel(errSiblingFailed)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	if s, ok := summaryOf(name); ok {
+		log.Printf("[%s][SHARDS][SUMMARY] shards=%d totalRows=%d totalUpdated=%d totalSkipped=%d urlsCleaned=%d",
+			label, len(ranges), s.rows, s.updated, s.skipped, s.urlsCleaned)
+	}
+	merged.stats.logSummary(context.Background(), label, pkCol)
+
+	var limitErr, failed error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, errMaxWritesReached):
+			if limitErr == nil {
+				limitErr = err
+			}
+		default:
+			log.Printf("[%s] shard %d/%d: %v", label, ranges[i].shard, ranges[i].of, err)
+			// Prefer the shard that failed on its own over the ones it cancelled.
+			if failed == nil || errors.Is(failed, errSiblingFailed) && !errors.Is(err, errSiblingFailed) {
+				failed = fmt.Errorf("shard %d/%d: %w", ranges[i].shard, ranges[i].of, err)
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	return limitErr
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestBatchQueriesStopAtShardEnd(t *testing.T) {
+	withCleaningConfig(t)
+	if err := loadFilterColumns(); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p []string) { hydraSignPrefixes = p }(hydraSignPrefixes)
+	hydraSignPrefixes = []string{"https://api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?", "//api.dev-genesis.lionparcel.com/hydra/v1/asset/sign?"}
+	db := openTestDB(t)
+	seed, err := os.ReadFile("scripts/sqlite-seed.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(string(seed))
+	db.MustExec(`CREATE TABLE documents (doc_id INTEGER PRIMARY KEY, file_url TEXT)`)
+	db.MustExec(`INSERT INTO documents VALUES (1, 'https://h/a?tag=1'), (2, ''), (3, NULL), (7, 'https://h/b?tag=1')`)
+	docs := genericTable{Table: "documents", PKColumn: "doc_id", URLColumn: "file_url"}
+
+	// Every table has candidates past pk 2; a shard ending there must not fetch them.
+	ctx := context.WithValue(t.Context(), shardKey{}, pkRange{lo: 0, hi: 2, shard: 1, of: 2})
+	for _, tc := range []struct {
+		name  string
+		build func(ctx context.Context) (string, []interface{})
+		want  []int64
+	}{
+		{"bulk", func(ctx context.Context) (string, []interface{}) { return bulkBatchQuery(ctx, 0, 10) }, []int64{1, 2}},
+		{"partner", func(ctx context.Context) (string, []interface{}) { return partnerBatchQuery(ctx, 0, 10) }, []int64{1, 2}},
+		{"client", func(ctx context.Context) (string, []interface{}) { return clientBatchQuery(ctx, 0, 10) }, []int64{1, 2}},
+		{"generic", func(ctx context.Context) (string, []interface{}) {
+			return genericBatchQuery(ctx, docs, docs.firstPK(), 10)
+		}, []int64{1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			query, args := tc.build(ctx)
+			rows, err := db.QueryContext(t.Context(), query, args...)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, query)
+			}
+			defer rows.Close()
+			cols, _ := rows.Columns()
+			var got []int64
+			for rows.Next() {
+				dest := make([]interface{}, len(cols))
+				var pk int64
+				dest[0] = &pk
+				for i := 1; i < len(dest); i++ {
+					dest[i] = new(interface{})
+				}
+				if err := rows.Scan(dest...); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, pk)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("shard (0, 2] fetched pks %v, want %v", got, tc.want)
+			}
+
+			// Without a shard the same query reaches past pk 2.
+			query, args = tc.build(t.Context())
+			var n int
+			if err := db.GetContext(t.Context(), &n, "SELECT COUNT(*) FROM ("+query+")", args...); err != nil {
+				t.Fatal(err)
+			}
+			if n <= len(tc.want) {
+				t.Errorf("unsharded query fetched %d rows, want more than %d", n, len(tc.want))
+			}
+		})
+	}
+}
+
+func TestShardStatsMerged(t *testing.T) {
+	saved := longestURLsN
+	t.Cleanup(func() { longestURLsN = saved })
+	longestURLsN = 2
+
+	merged := &shardStats{stats: newMigrationStats()}
+	ctx := context.WithValue(t.Context(), shardStatsKey{}, merged)
+	for i, urls := range [][]string{
+		{"https://a.example.com/1", "https://b.example.com/22"},
+		{"https://a.example.com/333", "https://a.example.com/4444"},
+	} {
+		s := newMigrationStats()
+		for _, u := range urls {
+			s.addRowHosts(u)
+			s.trackLongest(intPK(int64(i)), u)
+		}
+		s.skip(skipAlreadyClean)
+		s.urlsCleaned = i + 1
+		s.logSummary(ctx, "DOCS", "doc_id")
+	}
+
+	got := merged.stats
+	if got.hosts["a.example.com"] != 3 || got.hosts["b.example.com"] != 1 {
+		t.Errorf("hosts = %v", got.hosts)
+	}
+	if got.skipReasons[skipAlreadyClean] != 2 || got.urlsCleaned != 3 {
+		t.Errorf("skipReasons = %v urlsCleaned = %d", got.skipReasons, got.urlsCleaned)
+	}
+	if len(got.longest) != 2 {
+		t.Fatalf("longest = %v, want the top 2", got.longest)
+	}
+	for _, u := range got.longest {
+		if u.url != "https://a.example.com/333" && u.url != "https://a.example.com/4444" {
+			t.Errorf("longest holds %q, not one of the 2 longest URLs", u.url)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ------------------------------
+// Change sinks (SINK)
+// ------------------------------
+
+// changeSink receives every applied change as an event, in addition to (or instead of) the
+// direct UPDATE, so downstream systems can react to cleanups.
+type changeSink interface {
+	Apply(ctx context.Context, rec changeRecord) error
+	Close() error
+}
+
+// SINK selects where applied changes go, comma-separated: "db" (default) is the direct UPDATE,
+// "kafka" emits a change event to KAFKA_TOPIC on KAFKA_BROKERS. With SINK=kafka alone the tables
+// are left untouched and downstream consumers are expected to apply the change.
+var (
+	sinkDB     = true
+	eventSinks []changeSink
+)
+
+// parseSinks configures sinkDB and eventSinks from the SINK spec.
+func parseSinks(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		spec = "db"
+	}
+	sinkDB = false
+	eventSinks = nil
+	for _, name := range strings.Split(spec, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "db":
+			sinkDB = true
+		case "kafka":
+			s, err := newKafkaSink()
+			if err != nil {
+				return err
+			}
+			eventSinks = append(eventSinks, s)
+		default:
+			return &ConfigError{Key: "SINK", Err: fmt.Errorf("unknown sink %q (want db, kafka)", name)}
+		}
+	}
+	return nil
+}
+
+// applyChange delivers one applied (non-dry-run) change: first the direct write via writeDB when
+// the db sink is enabled, then recordChange (held until the commit under BATCH_TX, see
+// recordAppliedChange), then every event sink. An event is only sent after
+// the write succeeded, and a failed event never loses the report/rollback entry of a done write.
+func applyChange(ctx context.Context, rec changeRecord, writeDB func() error) error {
+	if sinkDB {
+		if err := writeDB(); err != nil {
+			return err
+		}
+	}
+	recordAppliedChange(ctx, rec)
+	for _, s := range eventSinks {
+		if err := s.Apply(ctx, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeSinks flushes and closes the event sinks.
+func closeSinks() error {
+	var firstErr error
+	for _, s := range eventSinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// changeEvent is the JSON payload of a change event.
+type changeEvent struct {
+	Event string `json:"event"`
+	changeRecord
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+type kafkaSink struct {
+	w *kafka.Writer
+}
+
+func newKafkaSink() (*kafkaSink, error) {
+	var brokers []string
+	for _, b := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, &ConfigError{Key: "KAFKA_BROKERS", Err: fmt.Errorf("required with SINK=kafka")}
+	}
+	topic := strings.TrimSpace(os.Getenv("KAFKA_TOPIC"))
+	if topic == "" {
+		return nil, &ConfigError{Key: "KAFKA_TOPIC", Err: fmt.Errorf("required with SINK=kafka")}
+	}
+	return &kafkaSink{w: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Every change is written synchronously; don't wait for a batch to fill up.
+		BatchTimeout: 10 * time.Millisecond,
+	}}, nil
+}
+
+// Apply sends rec keyed by table:pk, so all events for one row land on the same partition in order.
+func (s *kafkaSink) Apply(ctx context.Context, rec changeRecord) error {
+	rec.sortColumns()
+	payload, err := json.Marshal(changeEvent{Event: "url_tag_removed", changeRecord: rec, AppliedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	msg := kafka.Message{
+		Key:   []byte(rec.Table + ":" + rec.PK.String()),
+		Value: payload,
+	}
+	if err := s.w.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("kafka sink: %w", err)
+	}
+	return nil
+}
+
+func (s *kafkaSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"hash/crc32"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ------------------------------
+// Per-migration stats
+// ------------------------------
+
+// migrationStats collects data-quality tallies for a single migration run.
+// It works the same in dry-run and real mode.
+type migrationStats struct {
+	// hosts maps each distinct URL host (before rewriting) to the number of rows it appeared in.
+	hosts map[string]int
+	// changedHosts maps each host of the original URLs changed in a row to the number of rows
+	// updated (or planned in dry-run), so rows moved off each host/bucket can be compared.
+	changedHosts map[string]int
+	// urlsCleaned counts individual URLs changed (or planned in dry-run): one per bulk row,
+	// one per partner file, one per client column.
+	urlsCleaned int
+	// skipReasons counts URLs skipped for a specific reason (e.g. regex-include, regex-exclude).
+	skipReasons map[string]int
+	// canaryIn / canaryOut count rows inside and outside the CANARY_PERCENT subset.
+	canaryIn, canaryOut int
+	// longest holds the LONGEST_URLS longest URLs seen (see trackLongest).
+	longest longURLHeap
+	// verifyExact / verifyDrift / verifyAmbiguous count DRYRUN_VERIFY outcomes (1, 0, >1 rows).
+	verifyExact, verifyDrift, verifyAmbiguous int
+}
+
+func newMigrationStats() *migrationStats {
+	return &migrationStats{
+		hosts:        make(map[string]int),
+		changedHosts: make(map[string]int),
+		skipReasons:  make(map[string]int),
+	}
+}
+
+// merge adds the tallies of o, e.g. of one shard of the migration, to s.
+func (s *migrationStats) merge(o *migrationStats) {
+	for h, n := range o.hosts {
+		s.hosts[h] += n
+	}
+	for h, n := range o.changedHosts {
+		s.changedHosts[h] += n
+	}
+	for r, n := range o.skipReasons {
+		s.skipReasons[r] += n
+	}
+	s.urlsCleaned += o.urlsCleaned
+	s.canaryIn += o.canaryIn
+	s.canaryOut += o.canaryOut
+	s.verifyExact += o.verifyExact
+	s.verifyDrift += o.verifyDrift
+	s.verifyAmbiguous += o.verifyAmbiguous
+	for _, u := range o.longest {
+		s.trackLongest(u.pk, u.url)
+	}
+}
+
+// logSummary prints the host, skip, canary, verify and longest-URL summaries of the migration.
+// A shard of a sharded migration merges its stats into the migration's instead, which
+// runMigrationSharded prints once every shard is done.
+func (s *migrationStats) logSummary(ctx context.Context, label, pkCol string) {
+	if m, ok := ctx.Value(shardStatsKey{}).(*shardStats); ok {
+		m.add(s)
+		return
+	}
+	s.logHosts(label)
+	s.logSkips(label)
+	s.logCanary(label)
+	s.logVerify(label)
+	s.logLongest(label, pkCol)
+}
+
+// addRowHosts tallies the hosts of the URLs seen in one row. A host is counted at most once per row.
+func (s *migrationStats) addRowHosts(urls ...string) {
+	seen := make(map[string]bool, len(urls))
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		h := urlHost(raw)
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		s.hosts[h]++
+	}
+}
+
+// addChangedHosts tallies the hosts of the original URLs changed by rec. A host is counted at
+// most once per row.
+func (s *migrationStats) addChangedHosts(rec changeRecord) {
+	seen := make(map[string]bool)
+	for _, c := range rec.Columns {
+		for _, u := range changedURLs(c.Old, c.New) {
+			h := urlHost(u)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			s.changedHosts[h]++
+		}
+	}
+}
+
+// logHosts prints the host tally of all rows seen and of the updated rows, most frequent first.
+// A row whose changed URLs span several hosts counts once for each.
+func (s *migrationStats) logHosts(label string) {
+	hosts := hostsByCount(s.hosts)
+	log.Printf("[%s][SUMMARY] distinct hosts=%d", label, len(hosts))
+	for _, h := range hosts {
+		log.Printf("[%s][SUMMARY]   host=%s rows=%d", label, h, s.hosts[h])
+	}
+
+	changed := hostsByCount(s.changedHosts)
+	log.Printf("[%s][SUMMARY] updated rows by original host: hosts=%d", label, len(changed))
+	for _, h := range changed {
+		log.Printf("[%s][SUMMARY]   host=%s updated=%d", label, h, s.changedHosts[h])
+	}
+}
+
+// hostsByCount returns the hosts of counts, highest count first, ties by name.
+func hostsByCount(counts map[string]int) []string {
+	hosts := make([]string, 0, len(counts))
+	for h := range counts {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if counts[hosts[i]] != counts[hosts[j]] {
+			return counts[hosts[i]] > counts[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+	return hosts
+}
+
+// skip records one URL skipped for reason.
+func (s *migrationStats) skip(reason string) {
+	s.skipReasons[reason]++
+}
+
+// skipAlreadyClean is the skip reason for a row whose stored value already equals what the
+// enabled transforms would write for it, e.g. a row cleaned by a run that crashed before it was
+// marked or checkpointed.
+const skipAlreadyClean = "already-clean"
+
+// alreadyClean reports whether final, the fully transformed value (normalization and host
+// rewrites included), equals the stored value; if so the row is counted under skipAlreadyClean
+// and must not be written.
+func (s *migrationStats) alreadyClean(stored, final string) bool {
+	if stored != final {
+		return false
+	}
+	s.skip(skipAlreadyClean)
+	return true
+}
+
+// logSkips prints the skip counts by reason, if any.
+func (s *migrationStats) logSkips(label string) {
+	if len(s.skipReasons) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(s.skipReasons))
+	for r := range s.skipReasons {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	for _, r := range reasons {
+		log.Printf("[%s][SUMMARY] skipped reason=%s count=%d", label, r, s.skipReasons[r])
+	}
+}
+
+// canaryPercent (CANARY_PERCENT, 1-100) restricts processing to a stable pseudo-random subset
+// of rows spread over the whole pk range; 0 disables the canary.
+var canaryPercent int
+
+// inCanary reports whether pk belongs to the canary subset (crc32(pk) % 100 < percent) and
+// tallies the result. Always true when no canary is configured.
+func (s *migrationStats) inCanary(pk pkValue) bool {
+	if canaryPercent <= 0 {
+		return true
+	}
+	if crc32.ChecksumIEEE([]byte(pk.String()))%100 < uint32(canaryPercent) {
+		s.canaryIn++
+		return true
+	}
+	s.canaryOut++
+	return false
+}
+
+// logCanary prints the canary in/out counts when a canary is configured.
+func (s *migrationStats) logCanary(label string) {
+	if canaryPercent <= 0 {
+		return
+	}
+	log.Printf("[%s][SUMMARY] canary=%d%% inCanary=%d outOfCanary=%d", label, canaryPercent, s.canaryIn, s.canaryOut)
+}
+
+// urlHost returns the lower-cased host of rawURL, or a placeholder when there is none.
+func urlHost(rawURL string) string {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return "(unparseable)"
+	}
+	if u.Host == "" {
+		return "(no-host)"
+	}
+	return strings.ToLower(u.Host)
+}
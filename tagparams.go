@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Tag params (TAG_PARAMS)
+// ------------------------------
+
+// tagParams are the query param names removed from URLs. TAG_PARAMS overrides the default,
+// e.g. TAG_PARAMS=tagging removes only the deprecated param and leaves tag alone.
+var tagParams = []string{"tag", "tagging"}
+
+// tagParamPattern restricts TAG_PARAMS entries to plain query keys.
+var tagParamPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// parseTagParams parses the comma-separated TAG_PARAMS list.
+func parseTagParams(spec string) ([]string, error) {
+	var params []string
+	seen := make(map[string]bool)
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !tagParamPattern.MatchString(p) {
+			return nil, &ConfigError{Key: "TAG_PARAMS", Err: fmt.Errorf("invalid param name %q", p)}
+		}
+		if seen[p] {
+			return nil, &ConfigError{Key: "TAG_PARAMS", Err: fmt.Errorf("duplicate param name %q", p)}
+		}
+		seen[p] = true
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return nil, &ConfigError{Key: "TAG_PARAMS", Err: fmt.Errorf("no param names in %q", spec)}
+	}
+	return params, nil
+}
+
+// tagParamsQuery (TAG_PARAMS_QUERY) is a SELECT returning the tag param names in its single
+// column, e.g. from the platform settings table, so the list has one source of truth. It is run
+// on every connected database and its result replaces TAG_PARAMS/the default list.
+var tagParamsQuery string
+
+// loadTagParamsFromDB runs TAG_PARAMS_QUERY and, if it returns at least one name, uses the names
+// as tagParams. NULL and blank values are ignored and duplicates dropped. On a query error, an
+// empty result or any name that is not a plain query key the TAG_PARAMS/default list is kept
+// and a [WARN] is logged.
+func loadTagParamsFromDB(ctx context.Context, db *sqlx.DB) {
+	if tagParamsQuery == "" {
+		return
+	}
+	var names []sql.NullString
+	if err := db.SelectContext(ctx, &names, tagParamsQuery); err != nil {
+		log.Printf("[WARN] TAG_PARAMS_QUERY failed, keeping TAG_PARAMS=%s: %v", strings.Join(tagParams, ","), err)
+		return
+	}
+
+	var params []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		name := strings.TrimSpace(n.String)
+		if !n.Valid || name == "" || seen[name] {
+			continue
+		}
+		if !tagParamPattern.MatchString(name) {
+			log.Printf("[WARN] TAG_PARAMS_QUERY returned invalid param name %q, keeping TAG_PARAMS=%s", name, strings.Join(tagParams, ","))
+			return
+		}
+		seen[name] = true
+		params = append(params, name)
+	}
+	if len(params) == 0 {
+		log.Printf("[WARN] TAG_PARAMS_QUERY returned no param names, keeping TAG_PARAMS=%s", strings.Join(tagParams, ","))
+		return
+	}
+	tagParams = params
+	log.Printf("TAG_PARAMS_QUERY: tag params loaded from the database: %s", strings.Join(tagParams, ","))
+	checkPrefilterTagParams()
+}
+
+// checkPrefilterTagParams disables PARTNER_JSON_PREFILTER when a tag param does not contain
+// "tag": the prefilter only matches strings containing "tag" and would hide rows carrying it.
+func checkPrefilterTagParams() {
+	for _, p := range tagParams {
+		if partnerJSONPrefilter && !strings.Contains(p, "tag") {
+			log.Printf("[WARN] TAG_PARAMS contains %q, disabling PARTNER_JSON_PREFILTER", p)
+			partnerJSONPrefilter = false
+		}
+	}
+}
+
+// removedParamCounts counts, per tag param, the occurrences removed from the URLs of recorded
+// changes in this run (dry-run included), so the final summary shows exactly which params were
+// dropped. Rows skipped after cleaning (would-truncate, resign-failed, ...) are not counted.
+var (
+	removedParamCounts   = make(map[string]int)
+	removedParamCountsMu sync.Mutex
+)
+
+// countRemovedParams adds the keys of removed, raw tag param pairs as returned by
+// removedTagPairs, to removedParamCounts.
+func countRemovedParams(removed []string) {
+	if len(removed) == 0 {
+		return
+	}
+	removedParamCountsMu.Lock()
+	defer removedParamCountsMu.Unlock()
+	for _, pair := range removed {
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		removedParamCounts[key]++
+	}
+}
+
+// removedTagPairs returns the raw tag param pairs (e.g. "tag=abc123") of oldURL that are missing
+// from newURL, in their original order.
+func removedTagPairs(oldURL, newURL string) []string {
+	_, oldURL, _ = cutStoragePrefix(oldURL)
+	_, newURL, _ = cutStoragePrefix(newURL)
+	oldU, err := parseURL(oldURL)
+	if err != nil {
+		return nil
+	}
+	newU, err := parseURL(newURL)
+	if err != nil {
+		return nil
+	}
+
+	kept := make(map[string]int)
+	for _, pair := range strings.Split(newU.RawQuery, "&") {
+		kept[pair]++
+	}
+	var removed []string
+	for _, pair := range strings.Split(oldU.RawQuery, "&") {
+		if kept[pair] > 0 {
+			kept[pair]--
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if isTagParam(key) {
+			removed = append(removed, pair)
+		}
+	}
+	return removed
+}
+
+func isTagParam(key string) bool {
+	for _, p := range tagParams {
+		if p == key {
+			return true
+		}
+	}
+	return false
+}
+
+// logRemovedParams prints the per-param removal counts of the run.
+func logRemovedParams() {
+	names := make([]string, 0, len(removedParamCounts))
+	for k := range removedParamCounts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, k := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, removedParamCounts[k]))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "none")
+	}
+	log.Printf("[SUMMARY] TAG_PARAMS=%s removed: %s", strings.Join(tagParams, ","), strings.Join(parts, " "))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"maps"
+	"testing"
+)
+
+// withRemovedParamCounts starts the test with empty removal counts and restores them afterwards.
+func withRemovedParamCounts(t *testing.T) {
+	t.Helper()
+	saved := removedParamCounts
+	t.Cleanup(func() { removedParamCounts = saved })
+	removedParamCounts = make(map[string]int)
+}
+
+func TestTagParamsTaggingOnly(t *testing.T) {
+	withCleaningConfig(t)
+	withRemovedParamCounts(t)
+	params, err := parseTagParams(" tagging ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagParams = params
+
+	tests := []struct {
+		in, want string
+		changed  bool
+	}{
+		{"https://h/a.pdf?tag=1&tagging=2&v=3", "https://h/a.pdf?tag=1&v=3", true},
+		{"https://h/a.pdf?tagging=2", "https://h/a.pdf", true},
+		{"https://h/a.pdf?tag=1&v=3", "https://h/a.pdf?tag=1&v=3", false},
+		{"https://h/a.pdf?tag=1", "https://h/a.pdf?tag=1", false},
+	}
+	for _, tc := range tests {
+		got, changed := cleanURL(tc.in)
+		if got != tc.want || changed != tc.changed {
+			t.Errorf("cleanURL(%q) = %q, %v; want %q, %v", tc.in, got, changed, tc.want, tc.changed)
+		}
+	}
+
+	// The summary counts only what was recorded, and only tagging.
+	stats := newMigrationStats()
+	row := BulkRow{ID: 1, ArchiveFile: sql.NullString{String: bulkS3Prefix + "a.xlsx?tag=1&tagging=2&tagging=3", Valid: true}}
+	if _, skipped, err := processBulkRowRemoveTag(t.Context(), nil, row, stats, true); err != nil || skipped {
+		t.Fatalf("processBulkRowRemoveTag: skipped=%v err=%v", skipped, err)
+	}
+	if want := map[string]int{"tagging": 2}; !maps.Equal(removedParamCounts, want) {
+		t.Errorf("removedParamCounts = %v, want %v", removedParamCounts, want)
+	}
+}
+
+func TestRemovedParamsNotCountedForSkippedRows(t *testing.T) {
+	withCleaningConfig(t)
+	withRemovedParamCounts(t)
+	saved := columnMaxLengths
+	t.Cleanup(func() { columnMaxLengths = saved })
+	columnMaxLengths = map[string]map[string]int{"bulk": {"archive_file": 10}}
+
+	stats := newMigrationStats()
+	row := BulkRow{ID: 1, ArchiveFile: sql.NullString{String: bulkS3Prefix + "a.xlsx?tag=1", Valid: true}}
+	if _, skipped, err := processBulkRowRemoveTag(t.Context(), nil, row, stats, true); err != nil || !skipped {
+		t.Fatalf("processBulkRowRemoveTag: skipped=%v err=%v, want skipped", skipped, err)
+	}
+	if stats.skipReasons[skipWouldTruncate] != 1 {
+		t.Fatalf("skipReasons = %v, want %s", stats.skipReasons, skipWouldTruncate)
+	}
+	if len(removedParamCounts) != 0 {
+		t.Errorf("removedParamCounts = %v for a skipped row, want none", removedParamCounts)
+	}
+}
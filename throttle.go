@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// ------------------------------
+// Batch sleep (BATCH_SLEEP)
+// ------------------------------
+
+// batchSleep is the pause after each processed batch (0 = none). With batchSleepJitter
+// (BATCH_SLEEP_JITTER=1) each pause is drawn from [0.5, 1.5) x batchSleep, so several jobs
+// or tables do not hit the database in lockstep.
+var (
+	batchSleep       time.Duration
+	batchSleepJitter bool
+)
+
+// sleepBetweenBatches waits the (jittered) BATCH_SLEEP, returning early if ctx is cancelled.
+func sleepBetweenBatches(ctx context.Context, label string) {
+	if batchSleep <= 0 {
+		return
+	}
+	d := batchSleep
+	if batchSleepJitter {
+		d = time.Duration(float64(batchSleep) * (0.5 + rand.Float64()))
+	}
+	log.Printf("[%s] sleeping %s before next batch", label, d.Round(time.Millisecond))
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
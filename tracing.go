@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// ------------------------------
+// OpenTelemetry tracing (OTEL_EXPORTER_OTLP_ENDPOINT)
+// ------------------------------
+
+// tracer records the run, each migration and each batch as spans. It is a no-op unless
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, in which case the
+// spans are exported over OTLP/HTTP; the exporter reads the other OTEL_EXPORTER_OTLP_* settings
+// (headers, timeout, ...) itself.
+var tracer trace.Tracer = noop.NewTracerProvider().Tracer("")
+
+// errBatchAborted marks a batch span that ended without its batch completing (error, interrupt,
+// MAX_WRITES).
+var errBatchAborted = errors.New("batch aborted")
+
+// setupTracing installs the OTLP tracer if an endpoint is configured. The returned function
+// flushes and stops it.
+func setupTracing(ctx context.Context) (func(), error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, &ConfigError{Key: "OTEL_EXPORTER_OTLP_ENDPOINT", Err: err}
+	}
+	// OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "rollback-url-tagging")),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, &ConfigError{Key: "OTEL_RESOURCE_ATTRIBUTES", Err: err}
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	tracer = tp.Tracer("rollback-url-tagging")
+	log.Println("OpenTelemetry tracing enabled (OTLP/HTTP)")
+
+	return func() {
+		// Flush even if the run was interrupted.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Printf("[WARN] flushing traces: %v", err)
+		}
+	}, nil
+}
+
+// endSpan records err (if any) on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// batchSpan traces one batch of a migration loop. The zero value is ready to use, and every
+// method is a no-op while no batch span is open.
+type batchSpan struct {
+	span trace.Span
+}
+
+// start opens the span of a fetched batch of rows pks first..last.
+func (b *batchSpan) start(ctx context.Context, name string, batchNum int, first, last interface{}, rows int) {
+	_, b.span = tracer.Start(ctx, "batch "+name, trace.WithAttributes(
+		attribute.String("migration", name),
+		attribute.Int("batch.num", batchNum),
+		attribute.Int("batch.rows", rows),
+		attribute.String("batch.id_range", fmt.Sprintf("%v..%v", first, last)),
+	))
+}
+
+// recordError adds a row error to the open span; the batch goes on.
+func (b *batchSpan) recordError(err error) {
+	if b.span != nil {
+		b.span.RecordError(err)
+	}
+}
+
+// end closes the span of a committed batch.
+func (b *batchSpan) end(updated int) {
+	if b.span == nil {
+		return
+	}
+	b.span.SetAttributes(attribute.Int("batch.updated", updated))
+	b.span.End()
+	b.span = nil
+}
+
+// abort closes a span still open when the migration returns or leaves its loop early. Deferred
+// by every migration.
+func (b *batchSpan) abort() {
+	if b.span == nil {
+		return
+	}
+	endSpan(b.span, errBatchAborted)
+	b.span = nil
+}
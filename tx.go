@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ------------------------------
+// Batch transactions (BATCH_TX / MAX_TX_ROWS)
+// ------------------------------
+
+// batchTxEnabled (BATCH_TX=1) wraps the updates of each batch in one transaction, committed at
+// the end of the batch. maxTxRows (MAX_TX_ROWS) additionally commits as soon as that many rows
+// are pending, so lock duration stays bounded however large BATCH_SIZE is (0 = once per batch).
+var (
+	batchTxEnabled bool
+	maxTxRows      int
+)
+
+// batchTx tracks the open transaction of one migration. Without BATCH_TX every method is a
+// no-op and exec returns the plain DB.
+type batchTx struct {
+	db    *sqlx.DB
+	label string
+	tx    *sqlx.Tx
+	// pending counts updated rows in the open transaction; committedPK is the last pk of a
+	// committed row, for resuming after a failed commit.
+	pending     int
+	lastPK      pkValue
+	committedPK pkValue
+	// records are the change records of the open transaction, written by commit once the
+	// transaction is committed, so the report and rollback script never list rolled-back rows.
+	records []changeRecord
+}
+
+// batchTxKey is the context key of the batchTx whose records a row's applied change joins.
+type batchTxKey struct{}
+
+// rowContext returns the context to process a row with, carrying b while a transaction is open.
+func (b *batchTx) rowContext(ctx context.Context) context.Context {
+	if b.tx == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, batchTxKey{}, b)
+}
+
+// recordAppliedChange records rec, an applied change: buffered in the open transaction of
+// ctx's batchTx, if any, otherwise right away.
+func recordAppliedChange(ctx context.Context, rec changeRecord) {
+	if b, ok := ctx.Value(batchTxKey{}).(*batchTx); ok && b.tx != nil {
+		b.records = append(b.records, rec)
+		return
+	}
+	recordChange(rec)
+}
+
+func newBatchTx(db *sqlx.DB, label string) *batchTx {
+	return &batchTx{db: db, label: label}
+}
+
+// exec is what row processors write through: the open transaction, or the DB.
+func (b *batchTx) exec() sqlx.ExtContext {
+	if b.tx != nil {
+		return b.tx
+	}
+	return b.db
+}
+
+// begin opens the transaction for the next rows.
+func (b *batchTx) begin(ctx context.Context) error {
+	if !batchTxEnabled || b.tx != nil {
+		return nil
+	}
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return &DBError{Op: "begin", Err: err}
+	}
+	b.tx = tx
+	return nil
+}
+
+// rowUpdated counts one updated row and sub-commits once MAX_TX_ROWS rows are pending.
+func (b *batchTx) rowUpdated(ctx context.Context, pk pkValue) error {
+	if b.tx == nil {
+		return nil
+	}
+	b.pending++
+	b.lastPK = pk
+	if maxTxRows > 0 && b.pending >= maxTxRows {
+		if err := b.commit(); err != nil {
+			return err
+		}
+		return b.begin(ctx)
+	}
+	return nil
+}
+
+// commit commits the open transaction, if any. On failure the pending rows are rolled back
+// and the error names the last committed pk to resume from.
+func (b *batchTx) commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	tx, pending, records := b.tx, b.pending, b.records
+	b.tx, b.pending, b.records = nil, 0, nil
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("commit %d pending %s updates (rolled back; last committed pk=%s): %w",
+			pending, b.label, b.committedPK, &DBError{Op: "commit", Err: err})
+	}
+	for _, rec := range records {
+		recordChange(rec)
+	}
+	if pending > 0 {
+		b.committedPK = b.lastPK
+		log.Printf("[%s] committed %d updates, last pk=%s", b.label, pending, b.committedPK)
+	}
+	return nil
+}
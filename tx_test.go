@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestBatchTxRecordsAfterCommit(t *testing.T) {
+	saved := batchTxEnabled
+	t.Cleanup(func() { batchTxEnabled = saved })
+	batchTxEnabled = true
+	records := captureReport(t)
+	db := openTestDB(t)
+	db.MustExec(`CREATE TABLE documents (doc_id INTEGER PRIMARY KEY, file_url TEXT)`)
+	db.MustExec(`INSERT INTO documents VALUES (1, 'https://h/a?tag=1'), (2, 'https://h/b?tag=2')`)
+
+	apply := func(btx *batchTx, id int64, newURL string) {
+		t.Helper()
+		rec := newChangeRecord("documents", "doc_id", intPK(id), false)
+		rec.addColumn("file_url", "old", newURL)
+		err := applyChange(btx.rowContext(t.Context()), rec, func() error {
+			_, err := btx.exec().ExecContext(t.Context(), `UPDATE documents SET file_url = ? WHERE doc_id = ?`, newURL, id)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := btx.rowUpdated(t.Context(), intPK(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	btx := newBatchTx(db, "DOCUMENTS")
+	if err := btx.begin(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	apply(btx, 1, "https://h/a")
+	if n := len(records()); n != 0 {
+		t.Fatalf("%d records written before the commit, want 0", n)
+	}
+	if err := btx.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if recs := records(); len(recs) != 1 || recs[0].PK.String() != "1" {
+		t.Fatalf("records after commit = %+v, want doc_id=1", recs)
+	}
+
+	// A failed commit rolls the row back and drops its record.
+	if err := btx.begin(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	apply(btx, 2, "https://h/b")
+	btx.tx.Rollback()
+	if err := btx.commit(); err == nil {
+		t.Fatal("commit of a rolled-back transaction succeeded")
+	}
+	if recs := records(); len(recs) != 1 {
+		t.Fatalf("records after failed commit = %+v, want only doc_id=1", recs)
+	}
+	var u string
+	if err := db.Get(&u, `SELECT file_url FROM documents WHERE doc_id = 2`); err != nil || u != "https://h/b?tag=2" {
+		t.Fatalf("doc_id=2 file_url = %q, %v; want it rolled back", u, err)
+	}
+
+	// Without an open transaction the record is written right away.
+	batchTxEnabled = false
+	apply(newBatchTx(db, "DOCUMENTS"), 2, "https://h/b")
+	if n := len(records()); n != 2 {
+		t.Fatalf("%d records without BATCH_TX, want 2", n)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// ------------------------------
+// Write budget (MAX_WRITES)
+// ------------------------------
+
+// maxWrites caps the number of UPDATE statements per run (0 = unlimited). Unlike a row cap it
+// ignores skipped rows, so it bounds binlog/replication volume directly.
+var maxWrites int
+
+// writesDone counts UPDATE statements executed so far in this run, shared by all migrations.
+var (
+	writesDone   int
+	writesDoneMu sync.Mutex
+)
+
+// errMaxWritesReached stops the running migration before the first write over budget.
+var errMaxWritesReached = errors.New("MAX_WRITES reached")
+
+// reserveWrite claims one write from the budget, or returns errMaxWritesReached if it is spent.
+func reserveWrite() error {
+	writesDoneMu.Lock()
+	defer writesDoneMu.Unlock()
+	if maxWrites > 0 && writesDone >= maxWrites {
+		return errMaxWritesReached
+	}
+	writesDone++
+	return nil
+}
+
+// writeLimitReached reports whether the MAX_WRITES budget is spent.
+func writeLimitReached() bool {
+	writesDoneMu.Lock()
+	defer writesDoneMu.Unlock()
+	return maxWrites > 0 && writesDone >= maxWrites
+}
+
+// stopOnWriteLimit ends the run cleanly once MAX_WRITES is hit. Re-running picks up the
+// remaining rows, since rows that were already cleaned no longer change.
+func stopOnWriteLimit(err error) error {
+	log.Printf("stopping: %v after %d writes; re-run to continue with the remaining rows", err, writesDone)
+	return nil
+}